@@ -1,63 +1,85 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/fourls/soko/internal/api"
 	"github.com/fourls/soko/internal/engine"
-	"github.com/fourls/soko/internal/sokofile"
+	"github.com/fourls/soko/internal/loader"
+	"github.com/fourls/soko/internal/secrets"
+	"github.com/fourls/soko/internal/store"
 	"github.com/fourls/soko/internal/web"
 	"github.com/gorilla/mux"
 )
 
-func sokofileToFlows(project *sokofile.Project) map[engine.FlowId]engine.Flow {
-	flows := make(map[engine.FlowId]engine.Flow, len(project.Flows))
+// secretsEnvPrefix marks environment variables of the daemon that hold
+// secrets, such as SOKO_SECRET_DEPLOY_TOKEN for the secret DEPLOY_TOKEN.
+const secretsEnvPrefix = "SOKO_SECRET_"
 
-	i := 0
-	for key, value := range project.Flows {
-		steps := make([]engine.Step, len(value.Steps))
-
-		for j, step := range value.Steps {
-			steps[j] = engine.Step{
-				Args: step.Cmd,
-			}
+// flagSet reports whether a flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
+	})
+	return set
+}
 
-		id := engine.FlowId(project.Name + "." + key)
+func main() {
+	projectsDir := flag.String("projects", ".", "directory to search for sokofiles")
+	reloadInterval := flag.Duration("reload", 5*time.Second, "how often to check sokofiles for changes, or 0 to disable")
+	historyFile := flag.String("history", "soko-jobs.jsonl", "file to keep job history in, or empty to keep it in memory")
+	workers := flag.Int("workers", 4, "number of jobs that may run at once")
+	secretsFile := flag.String("secrets", "", "file of NAME=value secrets for steps to reference")
+	apiToken := flag.String("api-token", "", "bearer token required to define or delete flows through the API, or empty to disallow it (default $SOKO_API_TOKEN)")
+	flag.Parse()
+
+	// read here rather than as the flag's default, which -h would print, and
+	// removed from the environment so steps do not inherit it
+	if !flagSet("api-token") {
+		*apiToken = os.Getenv("SOKO_API_TOKEN")
+	}
+	os.Unsetenv("SOKO_API_TOKEN")
 
-		var schedule *engine.FlowSchedule = nil
-		if value.Schedule != nil {
-			schedule = &engine.FlowSchedule{
-				Minutes: value.Schedule.Minutes(),
-				Hours:   value.Schedule.Hours(),
-				Days:    value.Schedule.Days(),
-			}
-		}
+	secretStore, err := secrets.Load(*secretsFile, secretsEnvPrefix)
+	if err != nil {
+		log.Fatalf("Failed to load secrets: %v", err)
+	}
 
-		flows[id] = engine.Flow{
-			Id:       id,
-			Steps:    steps,
-			Schedule: schedule,
+	var jobStore engine.JobStore
+	if *historyFile != "" {
+		fileStore, err := store.Open(*historyFile)
+		if err != nil {
+			log.Fatalf("Failed to open job history: %v", err)
 		}
-		i++
+		defer fileStore.Close()
+		jobStore = fileStore
 	}
 
-	return flows
-}
-
-func main() {
-	jobEngine := engine.New()
-	defer jobEngine.Close()
-
-	// todo support loading projects
-	project, err := sokofile.Parse("soko.yml")
+	jobEngine, err := engine.New(engine.Options{
+		Store:   jobStore,
+		Workers: *workers,
+		Secrets: secretStore,
+	})
 	if err != nil {
-		panic(err)
+		log.Fatalf("Failed to load job history: %v", err)
 	}
+	defer jobEngine.Close()
+
+	projects := loader.New(*projectsDir, &jobEngine.Flows)
+	projects.Load()
 
-	for id, flow := range sokofileToFlows(project) {
-		jobEngine.Flows.Create(id, flow)
+	if *reloadInterval > 0 {
+		reloadQuit := make(chan bool)
+		defer close(reloadQuit)
+		go projects.Watch(*reloadInterval, reloadQuit)
 	}
 
 	log.Print("Configured job engine")
@@ -65,7 +87,7 @@ func main() {
 	router := mux.NewRouter()
 
 	apiRouter := router.NewRoute().PathPrefix("/api/").Subrouter()
-	api.ConfigureRouter(apiRouter, &jobEngine)
+	api.ConfigureRouter(apiRouter, &jobEngine, api.Options{Token: *apiToken})
 	webRouter := router.NewRoute().Subrouter()
 	web.ConfigureRouter(webRouter, &jobEngine)
 
@@ -10,7 +10,13 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func ConfigureRouter(router *mux.Router, jobEngine *engine.JobEngine) {
+type Options struct {
+	// Token must be given as a bearer token to define or delete flows. If it
+	// is empty, flows can't be changed through the API.
+	Token string
+}
+
+func ConfigureRouter(router *mux.Router, jobEngine *engine.JobEngine, options Options) {
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add("Content-Type", "application/json")
@@ -24,22 +30,14 @@ func ConfigureRouter(router *mux.Router, jobEngine *engine.JobEngine) {
 		}{"pong"})
 	})
 
-	router.HandleFunc("/flows/{id}/run", func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		flowId := engine.FlowId(vars["id"])
-		found, jobId := jobEngine.StartJob(flowId)
-
-		if found {
-			json.NewEncoder(w).Encode(dto.Job{
-				JobId:  string(jobId),
-				FlowId: string(flowId),
-				State:  "pending",
-				Output: make([]dto.StepResult, 0),
-			})
-		} else {
-			http.Error(w, "Flow not found", 404)
-		}
-	}).Methods("POST")
+	router.HandleFunc("/flows", listFlows(jobEngine)).Methods("GET")
+	router.HandleFunc("/flows/{id}", getFlow(jobEngine)).Methods("GET")
+	router.HandleFunc("/flows/{id}", requireToken(options.Token, putFlow(jobEngine))).Methods("PUT")
+	router.HandleFunc("/flows/{id}", requireToken(options.Token, deleteFlow(jobEngine))).Methods("DELETE")
+
+	router.HandleFunc("/flows/{id}/run", runFlow(jobEngine)).Methods("POST")
+
+	router.HandleFunc("/jobs", listJobs(jobEngine)).Methods("GET")
 
 	router.HandleFunc("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -54,5 +52,25 @@ func ConfigureRouter(router *mux.Router, jobEngine *engine.JobEngine) {
 		json.NewEncoder(w).Encode(dto.FromJobInfo(id, &info))
 	}).Methods("GET")
 
+	router.HandleFunc("/jobs/{id}/logs", streamLogs(jobEngine)).Methods("GET")
+
+	router.HandleFunc("/jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := engine.JobId(vars["id"])
+
+		if _, ok := jobEngine.GetJob(id); !ok {
+			http.Error(w, "Job not found", 404)
+			return
+		}
+
+		if !jobEngine.CancelJob(id) {
+			http.Error(w, "Job has already finished", 409)
+			return
+		}
+
+		info, _ := jobEngine.GetJob(id)
+		json.NewEncoder(w).Encode(dto.FromJobInfo(id, &info))
+	}).Methods("POST")
+
 	log.Print("Configured API routes")
 }
@@ -0,0 +1,291 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fourls/soko/internal/api"
+	"github.com/fourls/soko/internal/api/dto"
+	"github.com/fourls/soko/internal/engine"
+	"github.com/gorilla/mux"
+)
+
+const testToken = "letmein"
+
+func newServer(t *testing.T) (*engine.JobEngine, *httptest.Server) {
+	t.Helper()
+
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(jobEngine.Close)
+
+	router := mux.NewRouter()
+	api.ConfigureRouter(router.NewRoute().PathPrefix("/api/").Subrouter(), &jobEngine, api.Options{Token: testToken})
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return &jobEngine, server
+}
+
+func TestStreamLogs(t *testing.T) {
+	jobEngine, server := newServer(t)
+
+	jobEngine.Flows.Create("p.flow", engine.Flow{
+		Id: "p.flow",
+		Steps: []engine.Step{
+			{Args: []string{"sh", "-c", "echo one; sleep 0.3; echo two >&2"}},
+			{Args: []string{"echo", "three"}},
+		},
+	})
+	_, id := jobEngine.StartJob("p.flow")
+
+	res, err := http.Get(server.URL + "/api/jobs/" + string(id) + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("got: content type %q, expected: text/event-stream", contentType)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := make(map[int]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || !strings.Contains(data, `"lines"`) {
+			continue
+		}
+
+		var chunk dto.LogOutput
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range chunk.Lines {
+			outputs[chunk.Step] += line.Stream + ": " + line.Text + "\n"
+		}
+	}
+
+	if outputs[0] != "stdout: one\nstderr: two\n" || outputs[1] != "stdout: three\n" {
+		t.Fatalf("got: %q, expected each step's output", outputs)
+	}
+
+	if !strings.Contains(string(body), `data: {"state":"succeeded"}`) || !strings.HasSuffix(string(body), "event: end\ndata: {}\n\n") {
+		t.Fatalf("got: %s, expected the stream to finish with the job", body)
+	}
+}
+
+func TestStreamLogsNotFound(t *testing.T) {
+	_, server := newServer(t)
+
+	res, err := http.Get(server.URL + "/api/jobs/missing/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 404 {
+		t.Fatalf("got: status %d, expected: 404", res.StatusCode)
+	}
+}
+
+func getJSON(t *testing.T, url string, value any) int {
+	t.Helper()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 200 {
+		if err := json.NewDecoder(res.Body).Decode(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestListJobs(t *testing.T) {
+	jobEngine, server := newServer(t)
+
+	jobEngine.Flows.Create("p.ok", engine.Flow{Id: "p.ok", Steps: []engine.Step{{Args: []string{"true"}}}})
+	jobEngine.Flows.Create("p.fail", engine.Flow{Id: "p.fail", Steps: []engine.Step{{Args: []string{"false"}}}})
+
+	ids := make(map[string]bool)
+	for _, flow := range []engine.FlowId{"p.ok", "p.fail", "p.ok"} {
+		_, id := jobEngine.StartJob(flow)
+		ids[string(id)] = true
+		// keep start times distinct
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	seen := make(map[string]bool)
+	url := server.URL + "/api/jobs?limit=2"
+	for page := 0; ; page++ {
+		var list dto.JobList
+		if status := getJSON(t, url, &list); status != 200 {
+			t.Fatalf("got: status %d, expected: 200", status)
+		}
+		for _, job := range list.Jobs {
+			seen[job.JobId] = true
+		}
+		if list.NextCursor == "" {
+			break
+		}
+		if page > 2 {
+			t.Fatalf("got: more pages than expected")
+		}
+		url = server.URL + "/api/jobs?limit=2&cursor=" + list.NextCursor
+	}
+	if len(seen) != len(ids) {
+		t.Fatalf("got: %v, expected every job: %v", seen, ids)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var list dto.JobList
+		getJSON(t, server.URL+"/api/jobs?flow=p.fail&state=failed,timed_out", &list)
+		if len(list.Jobs) == 1 && list.Jobs[0].FlowId == "p.fail" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got: %+v, expected the failed job", list.Jobs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, query := range []string{"state=bogus", "since=yesterday", "limit=0", "order=sideways", "cursor=nonsense!"} {
+		if status := getJSON(t, server.URL+"/api/jobs?"+query, nil); status != 400 {
+			t.Fatalf("%s got: status %d, expected: 400", query, status)
+		}
+	}
+}
+
+func request(t *testing.T, method string, url string, token string, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func TestManageFlows(t *testing.T) {
+	jobEngine, server := newServer(t)
+	url := server.URL + "/api/flows/adhoc.hello"
+	definition := `
+schedule:
+  cron: "0 9 * * *"
+timezone: UTC
+steps:
+  - run: echo hello
+`
+
+	if res := request(t, "PUT", url, "", definition); res.StatusCode != 401 {
+		t.Fatalf("got: status %d without a token, expected: 401", res.StatusCode)
+	}
+	if res := request(t, "PUT", url, "wrong", definition); res.StatusCode != 401 {
+		t.Fatalf("got: status %d with the wrong token, expected: 401", res.StatusCode)
+	}
+	if res := request(t, "PUT", url, testToken, "steps:\n  - {}\n"); res.StatusCode != 400 {
+		t.Fatalf("got: status %d for an invalid flow, expected: 400", res.StatusCode)
+	}
+	if res := request(t, "PUT", url, testToken, definition); res.StatusCode != 201 {
+		t.Fatalf("got: status %d, expected: 201", res.StatusCode)
+	}
+
+	_, id := jobEngine.StartJob("adhoc.hello")
+
+	var flow dto.Flow
+	if status := getJSON(t, url, &flow); status != 200 {
+		t.Fatalf("got: status %d, expected: 200", status)
+	}
+	if len(flow.Steps) != 1 || strings.Join(flow.Steps[0].Args, " ") != "sh -c echo hello" {
+		t.Fatalf("got: %+v, expected the step to run through the shell", flow.Steps)
+	}
+	if flow.NextRun == nil || flow.NextRun.UTC().Hour() != 9 || flow.Schedule.Timezone != "UTC" {
+		t.Fatalf("got: next run %v with schedule %+v, expected 9:00 UTC", flow.NextRun, flow.Schedule)
+	}
+	if flow.LastJob == nil || flow.LastJob.JobId != string(id) {
+		t.Fatalf("got: %+v, expected the last job to be %s", flow.LastJob, id)
+	}
+
+	if res := request(t, "PUT", url, testToken, `{"steps": [{"cmd": ["true"]}]}`); res.StatusCode != 200 {
+		t.Fatalf("got: status %d replacing the flow with JSON, expected: 200", res.StatusCode)
+	}
+
+	var flows []dto.FlowSummary
+	getJSON(t, server.URL+"/api/flows", &flows)
+	if len(flows) != 1 || flows[0].FlowId != "adhoc.hello" || flows[0].Schedule != nil {
+		t.Fatalf("got: %+v, expected the replaced flow", flows)
+	}
+
+	jobEngine.Flows.Create("project.loaded", engine.Flow{Id: "project.loaded", Source: "soko.yml"})
+	if res := request(t, "DELETE", server.URL+"/api/flows/project.loaded", testToken, ""); res.StatusCode != 409 {
+		t.Fatalf("got: status %d deleting a loaded flow, expected: 409", res.StatusCode)
+	}
+
+	if res := request(t, "DELETE", url, testToken, ""); res.StatusCode != 204 {
+		t.Fatalf("got: status %d, expected: 204", res.StatusCode)
+	}
+	if status := getJSON(t, url, nil); status != 404 {
+		t.Fatalf("got: status %d after deleting, expected: 404", status)
+	}
+}
+
+func TestRunFlowWithInputs(t *testing.T) {
+	jobEngine, server := newServer(t)
+
+	jobEngine.Flows.Create("p.greet", engine.Flow{
+		Id: "p.greet",
+		Inputs: []engine.Input{
+			{Name: "name", Type: engine.InputString},
+			{Name: "loud", Type: engine.InputBool},
+		},
+		Steps: []engine.Step{{Args: []string{"echo", "hello ${{ inputs.name }} ${{ inputs.loud }}"}}},
+	})
+	url := server.URL + "/api/flows/p.greet/run"
+
+	for _, body := range []string{"", `{"inputs": {"name": "sam"}}`, `{"inputs": {"name": "sam", "loud": [true]}}`, "nonsense"} {
+		if res := request(t, "POST", url, "", body); res.StatusCode != 400 {
+			t.Fatalf("%q got: status %d, expected: 400", body, res.StatusCode)
+		}
+	}
+	if res := request(t, "POST", server.URL+"/api/flows/p.missing/run", "", ""); res.StatusCode != 404 {
+		t.Fatalf("got: status %d, expected: 404", res.StatusCode)
+	}
+
+	res := request(t, "POST", url, "", `{"inputs": {"name": "sam", "loud": true}}`)
+	if res.StatusCode != 200 {
+		t.Fatalf("got: status %d, expected: 200", res.StatusCode)
+	}
+	var job dto.Job
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Inputs["name"] != "sam" || job.Inputs["loud"] != "true" {
+		t.Fatalf("got: %v, expected the inputs to be recorded", job.Inputs)
+	}
+}
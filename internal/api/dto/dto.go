@@ -1,17 +1,109 @@
 package dto
 
-import "github.com/fourls/soko/internal/engine"
+import (
+	"time"
+
+	"github.com/fourls/soko/internal/engine"
+)
+
+// JobSummary is a job without its output, for listing many jobs at once.
+type JobSummary struct {
+	JobId  string `json:"id"`
+	FlowId string `json:"flow"`
+	State  string `json:"state"`
+	// TriggeredBy is the job whose end started this one, if any.
+	TriggeredBy string     `json:"triggered_by,omitempty"`
+	QueuedAt    *time.Time `json:"queued_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	DurationMs  int64      `json:"duration_ms"`
+}
 
 type Job struct {
-	JobId  string       `json:"id"`
-	FlowId string       `json:"flow"`
-	State  string       `json:"state"`
-	Output []StepResult `json:"output"`
+	JobSummary
+	Inputs map[string]string `json:"inputs,omitempty"`
+	Output []StepResult      `json:"output"`
+}
+
+// RunRequest is the optional body of a request to run a flow. Input values
+// may be JSON strings, booleans or numbers.
+type RunRequest struct {
+	Inputs map[string]any `json:"inputs"`
+}
+
+// JobList is a page of jobs. NextCursor fetches the next page, and is empty
+// on the last one.
+type JobList struct {
+	Jobs       []JobSummary `json:"jobs"`
+	NextCursor string       `json:"next_cursor,omitempty"`
 }
 
 type StepResult struct {
-	Input  string `json:"input"`
-	Output string `json:"output"`
+	Id         string     `json:"id,omitempty"`
+	State      string     `json:"state"`
+	Input      string     `json:"input"`
+	Output     string     `json:"output"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+	// ExitCode and Signal are from the latest attempt at the step.
+	ExitCode *int              `json:"exit_code,omitempty"`
+	Signal   string            `json:"signal,omitempty"`
+	Outputs  map[string]string `json:"outputs,omitempty"`
+	Lines    []OutputLine      `json:"lines"`
+	Attempts []StepAttempt     `json:"attempts"`
+}
+
+type OutputLine struct {
+	Time    time.Time `json:"time"`
+	Stream  string    `json:"stream"`
+	Text    string    `json:"text"`
+	Attempt int       `json:"attempt"`
+}
+
+type StepAttempt struct {
+	State      string     `json:"state"`
+	ExitCode   int        `json:"exit_code"`
+	Signal     string     `json:"signal,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+// optionalTime returns nil for the zero time, so unset times are left out.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func FromOutputLines(lines []engine.OutputLine) []OutputLine {
+	res := make([]OutputLine, len(lines))
+	for i, line := range lines {
+		res[i] = OutputLine{
+			Time:    line.Time,
+			Stream:  line.Stream.String(),
+			Text:    line.Text,
+			Attempt: line.Attempt,
+		}
+	}
+	return res
+}
+
+func FromStepAttempts(attempts []engine.StepAttempt) []StepAttempt {
+	res := make([]StepAttempt, len(attempts))
+	for i, attempt := range attempts {
+		res[i] = StepAttempt{
+			State:      attempt.State.String(),
+			ExitCode:   attempt.ExitCode,
+			Signal:     attempt.Signal,
+			StartedAt:  optionalTime(attempt.StartedAt),
+			FinishedAt: optionalTime(attempt.FinishedAt),
+			DurationMs: attempt.Duration().Milliseconds(),
+		}
+	}
+	return res
 }
 
 func FromJobInfo(id engine.JobId, info *engine.JobInfo) Job {
@@ -19,15 +111,173 @@ func FromJobInfo(id engine.JobId, info *engine.JobInfo) Job {
 	for i, step := range info.Steps {
 		// todo sanitize
 		output[i] = StepResult{
-			Input:  step.Input,
-			Output: string(step.Output),
+			Id:         step.Id,
+			State:      step.State.String(),
+			Input:      step.Input,
+			Output:     step.Output(),
+			StartedAt:  optionalTime(step.StartedAt),
+			FinishedAt: optionalTime(step.FinishedAt),
+			DurationMs: step.Duration().Milliseconds(),
+			Outputs:    step.Outputs,
+			Lines:      FromOutputLines(step.Lines),
+			Attempts:   FromStepAttempts(step.Attempts),
+		}
+		if result, ok := step.Result(); ok {
+			output[i].ExitCode = &result.ExitCode
+			output[i].Signal = result.Signal
 		}
 	}
 
 	return Job{
-		JobId:  string(id),
-		FlowId: string(info.FlowId),
-		State:  info.State.String(),
-		Output: output,
+		JobSummary: FromJobSummary(id, info),
+		Inputs:     info.Inputs,
+		Output:     output,
+	}
+}
+
+func FromJobSummary(id engine.JobId, info *engine.JobInfo) JobSummary {
+	return JobSummary{
+		JobId:       string(id),
+		FlowId:      string(info.FlowId),
+		State:       info.State.String(),
+		TriggeredBy: string(info.TriggeredBy),
+		QueuedAt:    optionalTime(info.QueuedAt),
+		StartedAt:   optionalTime(info.StartedAt),
+		FinishedAt:  optionalTime(info.FinishedAt),
+		DurationMs:  info.Duration().Milliseconds(),
+	}
+}
+
+// LogOutput is the lines a step has written since the last LogOutput for it.
+type LogOutput struct {
+	Step  int          `json:"step"`
+	Input string       `json:"input"`
+	Lines []OutputLine `json:"lines"`
+}
+
+type LogState struct {
+	State string `json:"state"`
+}
+
+type Schedule struct {
+	Description string `json:"description"`
+	Timezone    string `json:"timezone,omitempty"`
+	// Catchup is what the schedule does about missed runs.
+	Catchup string `json:"catchup"`
+}
+
+// FlowSummary is a flow without its steps, for listing many flows at once.
+type FlowSummary struct {
+	FlowId string `json:"id"`
+	// Source is the sokofile the flow was loaded from, and is empty for flows
+	// defined through the API.
+	Source   string      `json:"source,omitempty"`
+	Schedule *Schedule   `json:"schedule,omitempty"`
+	NextRun  *time.Time  `json:"next_run,omitempty"`
+	LastJob  *JobSummary `json:"last_job,omitempty"`
+}
+
+type Flow struct {
+	FlowSummary
+	Inputs        []FlowInput   `json:"inputs"`
+	Triggers      []FlowTrigger `json:"triggers"`
+	MaxConcurrent int           `json:"max_concurrent"`
+	TimeoutMs     int64         `json:"timeout_ms"`
+	Steps         []FlowStep    `json:"steps"`
+}
+
+type FlowInput struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Default     *string  `json:"default,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type FlowTrigger struct {
+	Flow string `json:"flow"`
+	When string `json:"when"`
+}
+
+type FlowStep struct {
+	Id string `json:"id,omitempty"`
+	// Needs are the indices of the steps that must succeed before this one.
+	Needs     []int             `json:"needs"`
+	Args      []string          `json:"args"`
+	Dir       string            `json:"dir,omitempty"`
+	TimeoutMs int64             `json:"timeout_ms"`
+	Env       map[string]string `json:"env,omitempty"`
+	// Secrets maps environment variables to the names of secrets, never
+	// their values.
+	Secrets         map[string]string `json:"secrets,omitempty"`
+	Outputs         []string          `json:"outputs,omitempty"`
+	If              string            `json:"if,omitempty"`
+	ContinueOnError bool              `json:"continue_on_error,omitempty"`
+}
+
+// FromFlowSummary summarises a flow, giving when its schedule next runs it
+// after now and how its latest job went, if it has one.
+func FromFlowSummary(flow *engine.Flow, now time.Time, lastJob *JobSummary) FlowSummary {
+	res := FlowSummary{
+		FlowId:  string(flow.Id),
+		Source:  flow.Source,
+		LastJob: lastJob,
+	}
+
+	if schedule := flow.Schedule; schedule != nil {
+		res.Schedule = &Schedule{Description: schedule.String(), Catchup: flow.Catchup.String()}
+		if schedule.Location != nil {
+			res.Schedule.Timezone = schedule.Location.String()
+		}
+		res.NextRun = optionalTime(schedule.Next(now))
+	}
+
+	return res
+}
+
+func FromFlow(flow *engine.Flow, now time.Time, lastJob *JobSummary) Flow {
+	steps := make([]FlowStep, len(flow.Steps))
+	for i, step := range flow.Steps {
+		needs := flow.StepNeeds(i)
+		if needs == nil {
+			needs = make([]int, 0)
+		}
+		steps[i] = FlowStep{
+			Id:              step.Id,
+			Needs:           needs,
+			Args:            step.Args,
+			Dir:             step.Dir,
+			TimeoutMs:       step.Timeout.Milliseconds(),
+			Env:             step.Env,
+			Secrets:         step.Secrets,
+			Outputs:         step.Outputs,
+			If:              step.If,
+			ContinueOnError: step.ContinueOnError,
+		}
+	}
+
+	inputs := make([]FlowInput, len(flow.Inputs))
+	for i, input := range flow.Inputs {
+		inputs[i] = FlowInput{
+			Name:        input.Name,
+			Type:        input.Type.String(),
+			Description: input.Description,
+			Default:     input.Default,
+			Options:     input.Options,
+		}
+	}
+
+	triggers := make([]FlowTrigger, len(flow.Triggers))
+	for i, trigger := range flow.Triggers {
+		triggers[i] = FlowTrigger{Flow: string(trigger.Flow), When: trigger.When.String()}
+	}
+
+	return Flow{
+		FlowSummary:   FromFlowSummary(flow, now, lastJob),
+		Inputs:        inputs,
+		Triggers:      triggers,
+		MaxConcurrent: flow.MaxConcurrent,
+		TimeoutMs:     flow.Timeout.Milliseconds(),
+		Steps:         steps,
 	}
 }
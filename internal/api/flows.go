@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fourls/soko/internal/api/dto"
+	"github.com/fourls/soko/internal/engine"
+	"github.com/fourls/soko/internal/loader"
+	"github.com/fourls/soko/internal/sokofile"
+	"github.com/gorilla/mux"
+)
+
+// maxFlowSize bounds the size of a flow definition sent to the API.
+const maxFlowSize = 1 << 20
+
+// lastJob returns a summary of the latest job of a flow, if it has run.
+func lastJob(jobEngine *engine.JobEngine, id engine.FlowId) *dto.JobSummary {
+	entry, ok := jobEngine.LastJob(id)
+	if !ok {
+		return nil
+	}
+
+	summary := dto.FromJobSummary(entry.Id, &entry.Info)
+	return &summary
+}
+
+// requireToken only lets requests through if they carry the token as a
+// bearer token. Without a token, every request is refused.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "Flow management is disabled", 403)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func listFlows(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flows := jobEngine.Flows.Snapshot()
+		ids := make([]engine.FlowId, 0, len(flows))
+		for id := range flows {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+
+		now := time.Now()
+		res := make([]dto.FlowSummary, len(ids))
+		for i, id := range ids {
+			flow := flows[id]
+			res[i] = dto.FromFlowSummary(&flow, now, lastJob(jobEngine, id))
+		}
+
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func getFlow(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := engine.FlowId(mux.Vars(r)["id"])
+
+		flow, ok := jobEngine.Flows.Read(id)
+		if !ok {
+			http.Error(w, "Flow not found", 404)
+			return
+		}
+
+		json.NewEncoder(w).Encode(dto.FromFlow(&flow, time.Now(), lastJob(jobEngine, id)))
+	}
+}
+
+// putFlow defines or replaces a flow from a flow definition in the request
+// body, written in yaml or JSON as it would be in a sokofile. Flows loaded
+// from sokofiles can't be replaced.
+func putFlow(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := engine.FlowId(mux.Vars(r)["id"])
+
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxFlowSize))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		definition, err := sokofile.ParseFlow(string(id), data)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		flow, err := loader.FlowToEngine(&sokofile.Project{}, id, *definition)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		if existing, ok := jobEngine.Flows.Read(id); ok && existing.Source != "" {
+			http.Error(w, "Flow is defined by "+existing.Source, 409)
+			return
+		}
+
+		status := 200
+		if !jobEngine.Flows.Update(id, func(engine.Flow) engine.Flow { return flow }) {
+			jobEngine.Flows.Create(id, flow)
+			status = 201
+		}
+
+		log.Printf("Defined flow %s through the API", id)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(dto.FromFlow(&flow, time.Now(), lastJob(jobEngine, id)))
+	}
+}
+
+// deleteFlow removes a flow defined through the API. Jobs already started
+// keep running.
+func deleteFlow(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := engine.FlowId(mux.Vars(r)["id"])
+
+		flow, ok := jobEngine.Flows.Read(id)
+		if !ok {
+			http.Error(w, "Flow not found", 404)
+			return
+		}
+		if flow.Source != "" {
+			http.Error(w, "Flow is defined by "+flow.Source, 409)
+			return
+		}
+
+		jobEngine.Flows.Delete(id)
+		log.Printf("Deleted flow %s through the API", id)
+		w.WriteHeader(204)
+	}
+}
+
+// inputValue converts a JSON input value to the string an input takes.
+func inputValue(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("expected a string, boolean or number")
+	}
+}
+
+// runFlow starts a job of a flow, taking its inputs from an optional
+// dto.RunRequest body.
+func runFlow(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flowId := engine.FlowId(mux.Vars(r)["id"])
+
+		var request dto.RunRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request: "+err.Error(), 400)
+			return
+		}
+
+		inputs := make(map[string]string, len(request.Inputs))
+		for name, value := range request.Inputs {
+			converted, err := inputValue(value)
+			if err != nil {
+				http.Error(w, (&engine.InputError{Input: name, Err: err}).Error(), 400)
+				return
+			}
+			inputs[name] = converted
+		}
+
+		jobId, err := jobEngine.StartJobWithOptions(flowId, engine.JobOptions{Inputs: inputs})
+		var inputErr *engine.InputError
+		if errors.Is(err, engine.ErrFlowNotFound) {
+			http.Error(w, "Flow not found", 404)
+			return
+		} else if errors.As(err, &inputErr) {
+			http.Error(w, err.Error(), 400)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		info, _ := jobEngine.GetJob(jobId)
+		json.NewEncoder(w).Encode(dto.FromJobInfo(jobId, &info))
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fourls/soko/internal/api/dto"
+	"github.com/fourls/soko/internal/engine"
+)
+
+// defaultJobLimit is how many jobs are listed per page unless asked otherwise.
+const defaultJobLimit = 50
+
+func parseTime(params url.Values, name string) (time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, expected an RFC 3339 time", name, value)
+	}
+	return t, nil
+}
+
+// parseJobQuery reads a job query from the URL parameters flow, state (a
+// comma-separated list, or repeated), since, until, order (asc or desc),
+// limit and cursor.
+func parseJobQuery(params url.Values) (engine.JobQuery, error) {
+	query := engine.JobQuery{
+		FlowId: engine.FlowId(params.Get("flow")),
+		Cursor: params.Get("cursor"),
+		Limit:  defaultJobLimit,
+	}
+
+	for _, value := range params["state"] {
+		for _, name := range strings.Split(value, ",") {
+			state, ok := engine.ParseJobState(strings.TrimSpace(name))
+			if !ok {
+				return query, fmt.Errorf("unknown state %q", name)
+			}
+			query.States = append(query.States, state)
+		}
+	}
+
+	var err error
+	if query.Since, err = parseTime(params, "since"); err != nil {
+		return query, err
+	}
+	if query.Until, err = parseTime(params, "until"); err != nil {
+		return query, err
+	}
+
+	switch order := params.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, fmt.Errorf("unknown order %q, expected asc or desc", order)
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("invalid limit %q", value)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// listJobs lists the jobs matching the request's query, a page at a time.
+func listJobs(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseJobQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		entries, next, err := jobEngine.QueryJobs(query)
+		if errors.Is(err, engine.ErrInvalidCursor) {
+			http.Error(w, err.Error(), 400)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		jobs := make([]dto.JobSummary, len(entries))
+		for i, entry := range entries {
+			jobs[i] = dto.FromJobSummary(entry.Id, &entry.Info)
+		}
+
+		json.NewEncoder(w).Encode(dto.JobList{
+			Jobs:       jobs,
+			NextCursor: next,
+		})
+	}
+}
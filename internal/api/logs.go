@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fourls/soko/internal/api/dto"
+	"github.com/fourls/soko/internal/engine"
+	"github.com/gorilla/mux"
+)
+
+// logPollInterval is how often a log stream checks its job for new output.
+const logPollInterval = 250 * time.Millisecond
+
+func writeEvent(w http.ResponseWriter, event string, data any) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+}
+
+// streamLogs sends a job's output as server-sent events until the job
+// finishes. Output is sent as "output" events carrying dto.LogOutput, and
+// changes of state as "state" events carrying dto.LogState. The stream ends
+// with an "end" event.
+func streamLogs(jobEngine *engine.JobEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := engine.JobId(vars["id"])
+
+		info, ok := jobEngine.GetJob(id)
+		if !ok {
+			http.Error(w, "Job not found", 404)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		ticker := time.NewTicker(logPollInterval)
+		defer ticker.Stop()
+
+		sent := make([]int, len(info.Steps))
+		state := ""
+		for {
+			for i, step := range info.Steps {
+				lines := step.Lines[sent[i]:]
+				if len(lines) > 0 {
+					writeEvent(w, "output", dto.LogOutput{
+						Step:  i,
+						Input: step.Input,
+						Lines: dto.FromOutputLines(lines),
+					})
+					sent[i] += len(lines)
+				}
+			}
+
+			if info.State.String() != state {
+				state = info.State.String()
+				writeEvent(w, "state", dto.LogState{State: state})
+			}
+
+			if info.State.Finished() {
+				writeEvent(w, "end", struct{}{})
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, _ = jobEngine.GetJob(id)
+		}
+	}
+}
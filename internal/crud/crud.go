@@ -28,6 +28,7 @@ type deleteRequest[K any] struct {
 }
 
 type snapshotRequest[K comparable, V any] struct {
+	keep     func(K, V) bool
 	receiver chan map[K]V
 }
 
@@ -39,6 +40,7 @@ func New[K comparable, V any]() Crud[K, V] {
 		updates:   make(chan updateRequest[K, V]),
 		deletes:   make(chan deleteRequest[K]),
 		snapshots: make(chan snapshotRequest[K, V]),
+		watches:   make(chan chan struct{}),
 		quit:      make(chan bool),
 	}
 	go crud.worker()
@@ -52,18 +54,33 @@ type Crud[K comparable, V any] struct {
 	updates   chan updateRequest[K, V]
 	deletes   chan deleteRequest[K]
 	snapshots chan snapshotRequest[K, V]
+	watches   chan chan struct{}
 	quit      chan bool
 }
 
 func (c *Crud[K, V]) worker() {
+	watchers := make([]chan struct{}, 0)
+	notify := func() {
+		for _, watcher := range watchers {
+			select {
+			case watcher <- struct{}{}:
+			default:
+				// already signalled
+			}
+		}
+	}
+
 	for {
 		select {
 		case <-c.quit:
 			return
+		case watcher := <-c.watches:
+			watchers = append(watchers, watcher)
 		case create := <-c.creates:
 			_, ok := c.values[create.key]
 			if !ok {
 				c.values[create.key] = create.value
+				notify()
 			}
 			create.ack <- !ok
 		case read := <-c.reads:
@@ -74,16 +91,22 @@ func (c *Crud[K, V]) worker() {
 			val, ok := c.values[update.key]
 			if ok {
 				c.values[update.key] = update.update(val)
+				notify()
 			}
 			update.ack <- ok
 		case del := <-c.deletes:
 			_, ok := c.values[del.key]
-			delete(c.values, del.key)
+			if ok {
+				delete(c.values, del.key)
+				notify()
+			}
 			del.ack <- ok
 		case snapshot := <-c.snapshots:
-			ret := make(map[K]V, len(c.values))
+			ret := make(map[K]V)
 			for k, v := range c.values {
-				ret[k] = v
+				if snapshot.keep == nil || snapshot.keep(k, v) {
+					ret[k] = v
+				}
 			}
 			snapshot.receiver <- ret
 		}
@@ -134,9 +157,24 @@ func (c *Crud[K, V]) Delete(key K) bool {
 }
 
 func (c *Crud[K, V]) Snapshot() map[K]V {
+	return c.Filter(nil)
+}
+
+// Filter returns a copy of the entries keep returns true for, or of every
+// entry if keep is nil. keep must not call back into c.
+func (c *Crud[K, V]) Filter(keep func(K, V) bool) map[K]V {
 	receiver := make(chan map[K]V)
 	c.snapshots <- snapshotRequest[K, V]{
+		keep:     keep,
 		receiver: receiver,
 	}
 	return <-receiver
 }
+
+// Watch returns a channel that is signalled after entries are created,
+// updated or deleted. Changes made before a signal is received share it.
+func (c *Crud[K, V]) Watch() <-chan struct{} {
+	watcher := make(chan struct{}, 1)
+	c.watches <- watcher
+	return watcher
+}
@@ -125,3 +125,55 @@ func TestCrudDeleteAbsent(t *testing.T) {
 		t.Fatalf("got: true when calling Delete, expected: false")
 	}
 }
+
+func TestCrudFilter(t *testing.T) {
+	crud := crud.New[int, string]()
+	defer crud.Close()
+
+	crud.Create(1, "foo")
+	crud.Create(2, "bar")
+	crud.Create(3, "baz")
+
+	values := crud.Filter(func(key int, value string) bool {
+		return key != 2
+	})
+	if len(values) != 2 || values[1] != "foo" || values[3] != "baz" {
+		t.Fatalf("got: %v, expected: map[1:foo 3:baz]", values)
+	}
+}
+
+func TestCrudWatch(t *testing.T) {
+	crud := crud.New[int, string]()
+	defer crud.Close()
+
+	changes := crud.Watch()
+	signalled := func() bool {
+		select {
+		case <-changes:
+			return true
+		default:
+			return false
+		}
+	}
+
+	crud.Create(1, "foo")
+	crud.Update(1, func(string) string { return "bar" })
+	if !signalled() {
+		t.Fatalf("got: no signal after changes, expected one")
+	}
+	if signalled() {
+		t.Fatalf("got: a second signal, expected changes to share one")
+	}
+
+	crud.Read(1)
+	crud.Delete(2)
+	crud.Create(1, "baz")
+	if signalled() {
+		t.Fatalf("got: a signal without a change, expected none")
+	}
+
+	crud.Delete(1)
+	if !signalled() {
+		t.Fatalf("got: no signal after delete, expected one")
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// CatchupPolicy is what a schedule does about runs it missed, such as while
+// the daemon was down or the host was suspended.
+type CatchupPolicy int
+
+const (
+	// CatchupSkip drops missed runs.
+	CatchupSkip CatchupPolicy = iota
+	// CatchupOnce starts a single job for the latest missed run.
+	CatchupOnce
+	// CatchupAll starts a job for every missed run, up to a limit.
+	CatchupAll
+)
+
+func (p CatchupPolicy) String() string {
+	switch p {
+	case CatchupSkip:
+		return "skip"
+	case CatchupOnce:
+		return "once"
+	case CatchupAll:
+		return "all"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultCatchupLimit is how many missed runs CatchupAll starts when no limit
+// is set.
+const DefaultCatchupLimit = 10
+
+// Catchup says how a flow's schedule handles missed runs.
+type Catchup struct {
+	Policy CatchupPolicy
+	// Limit caps how many of the latest missed runs CatchupAll starts, or is
+	// 0 for DefaultCatchupLimit.
+	Limit int
+}
+
+func (c Catchup) String() string {
+	if c.Policy == CatchupAll {
+		return fmt.Sprintf("all (up to %d)", c.limit())
+	}
+	return c.Policy.String()
+}
+
+func (c Catchup) limit() int {
+	if c.Limit > 0 {
+		return c.Limit
+	}
+	return DefaultCatchupLimit
+}
+
+// runs returns the times to start jobs for at now, out of those the
+// schedule was due at after last, along with the latest due time and how many
+// were skipped. A due time in the minute before now is on time rather than
+// missed, so always runs.
+func (c Catchup) runs(schedule FlowSchedule, last time.Time, now time.Time) ([]time.Time, time.Time, int) {
+	keep := 1
+	if c.Policy == CatchupAll {
+		keep = c.limit()
+	}
+
+	due, total := schedule.dueAfter(last, now, keep)
+	if total == 0 {
+		return nil, time.Time{}, 0
+	}
+
+	latest := due[len(due)-1]
+	var runs []time.Time
+	switch {
+	case c.Policy == CatchupAll:
+		runs = due
+	case c.Policy == CatchupOnce || now.Sub(latest) < time.Minute:
+		runs = due[len(due)-1:]
+	}
+	return runs, latest, total - len(runs)
+}
+
+// dueAfter returns the latest times, at most limit of them, that the
+// schedule was due to run after last and no later than now, along with how
+// many there were in all.
+func (s FlowSchedule) dueAfter(last time.Time, now time.Time, limit int) ([]time.Time, int) {
+	due := make([]time.Time, 0)
+	total := 0
+	for next := s.Next(last); !next.IsZero() && !next.After(now); next = s.Next(next) {
+		due = append(due, next)
+		if len(due) > limit {
+			due = due[1:]
+		}
+		total++
+	}
+	return due, total
+}
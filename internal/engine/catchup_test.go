@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCatchupRuns(t *testing.T) {
+	hourly := FlowSchedule{Minutes: []int{0}, Location: time.UTC}
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		catchup  Catchup
+		now      time.Time
+		expected []time.Time
+		skipped  int
+	}{
+		{Catchup{}, at(9, 30), nil, 0},
+		{Catchup{}, at(13, 30), nil, 4},
+		{Catchup{}, at(13, 0).Add(30 * time.Second), []time.Time{at(13, 0)}, 3},
+		{Catchup{Policy: CatchupOnce}, at(13, 30), []time.Time{at(13, 0)}, 3},
+		{Catchup{Policy: CatchupAll}, at(13, 30), []time.Time{at(10, 0), at(11, 0), at(12, 0), at(13, 0)}, 0},
+		{Catchup{Policy: CatchupAll, Limit: 2}, at(13, 30), []time.Time{at(12, 0), at(13, 0)}, 2},
+	}
+
+	for _, tc := range cases {
+		runs, latest, skipped := tc.catchup.runs(hourly, at(9, 0), tc.now)
+		if !slices.Equal(runs, tc.expected) || skipped != tc.skipped {
+			t.Fatalf("%v at %v got: %v, skipping %d, expected: %v, skipping %d", tc.catchup, tc.now, runs, skipped, tc.expected, tc.skipped)
+		}
+		if tc.now.After(at(10, 0)) && !latest.Equal(at(tc.now.Hour(), 0)) {
+			t.Fatalf("%v at %v got latest: %v", tc.catchup, tc.now, latest)
+		}
+	}
+}
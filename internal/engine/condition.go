@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Steps run when their condition is true, which by default is success().
+// Conditions are made of:
+//
+//   - variables, as in templates, and env.<name> for the step's environment
+//   - strings, quoted with ' or "
+//   - comparisons with == and !=
+//   - !, && and ||, with parentheses for grouping
+//   - success(), which is true if no step has failed and every step the step
+//     needs succeeded
+//   - failure(), which is true if a step has failed
+//   - always(), which is true
+//
+// Values are strings, and are true unless they are empty or "false".
+// Conditions that don't call success(), failure() or always() only hold
+// while success() does. A condition may also be wrapped in ${{ }}.
+//
+// Once a job is cancelled or times out it counts as having failed, so steps
+// whose condition still holds, such as always() and failure(), run to tear
+// down. Together they have a minute to finish.
+
+// conditionEnv is what a condition is evaluated against.
+type conditionEnv struct {
+	lookup func(name string) string
+	// failed is set if a step of the job has failed.
+	failed bool
+	// needsSucceeded is set if every step the step needs succeeded.
+	needsSucceeded bool
+}
+
+type conditionExpr func(env conditionEnv) string
+
+// condition is a parsed step condition.
+type condition struct {
+	text string
+	expr conditionExpr
+	// vars are the names of the variables the condition refers to.
+	vars []string
+	// status is set if the condition calls a status function, so doesn't
+	// implicitly need success().
+	status bool
+}
+
+func truthy(value string) bool {
+	return value != "" && value != "false"
+}
+
+func boolValue(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// holds evaluates the condition.
+func (c *condition) holds(env conditionEnv) bool {
+	if !c.status && !(env.needsSucceeded && !env.failed) {
+		return false
+	}
+	return truthy(c.expr(env))
+}
+
+// parseCondition parses a step's condition, where an empty condition is
+// success().
+func parseCondition(text string) (*condition, error) {
+	source := strings.TrimSpace(text)
+	if inner, ok := strings.CutPrefix(source, "${{"); ok {
+		if inner, ok = strings.CutSuffix(inner, "}}"); ok {
+			source = inner
+		}
+	}
+	if strings.TrimSpace(source) == "" {
+		source = "success()"
+	}
+
+	tokens, err := tokenizeCondition(source)
+	if err != nil {
+		return nil, fmt.Errorf("condition %q: %w", text, err)
+	}
+
+	p := &conditionParser{tokens: tokens, condition: &condition{text: text}}
+	expr, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("condition %q: %w", text, err)
+	}
+
+	p.condition.expr = expr
+	return p.condition, nil
+}
+
+// tokenizeCondition splits a condition into operators, parentheses, quoted
+// strings and names.
+func tokenizeCondition(text string) ([]string, error) {
+	tokens := make([]string, 0)
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(text[i:], "=="), strings.HasPrefix(text[i:], "!="),
+			strings.HasPrefix(text[i:], "&&"), strings.HasPrefix(text[i:], "||"):
+			tokens = append(tokens, text[i:i+2])
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			tokens = append(tokens, text[i:i+1])
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexRune(text[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed string %s", text[i:])
+			}
+			tokens = append(tokens, text[i:i+end+2])
+			i += end + 2
+		case isNameChar(c):
+			start := i
+			for i < len(text) && isNameChar(rune(text[i])) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func isNameChar(c rune) bool {
+	return c == '_' || c == '-' || c == '.' || c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c))
+}
+
+type conditionParser struct {
+	tokens    []string
+	pos       int
+	condition *condition
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *conditionParser) parseOr() (conditionExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var right conditionExpr
+		if right, err = p.parseAnd(); err == nil {
+			l := left
+			left = func(env conditionEnv) string {
+				return boolValue(truthy(l(env)) || truthy(right(env)))
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *conditionParser) parseAnd() (conditionExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var right conditionExpr
+		if right, err = p.parseNot(); err == nil {
+			l := left
+			left = func(env conditionEnv) string {
+				return boolValue(truthy(l(env)) && truthy(right(env)))
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *conditionParser) parseNot() (conditionExpr, error) {
+	if p.peek() != "!" {
+		return p.parseComparison()
+	}
+
+	p.pos++
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return func(env conditionEnv) string {
+		return boolValue(!truthy(operand(env)))
+	}, nil
+}
+
+func (p *conditionParser) parseComparison() (conditionExpr, error) {
+	left, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	operator := p.peek()
+	if operator != "==" && operator != "!=" {
+		return left, nil
+	}
+
+	p.pos++
+	right, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return func(env conditionEnv) string {
+		return boolValue((left(env) == right(env)) == (operator == "=="))
+	}, nil
+}
+
+func (p *conditionParser) parseValue() (conditionExpr, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case token == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing, err := p.next(); err != nil || closing != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	case token[0] == '\'' || token[0] == '"':
+		value := token[1 : len(token)-1]
+		return func(conditionEnv) string { return value }, nil
+	case !isNameChar(rune(token[0])):
+		return nil, fmt.Errorf("unexpected %q", token)
+	case p.peek() == "(":
+		return p.parseCall(token)
+	case token == "true" || token == "false":
+		return func(conditionEnv) string { return token }, nil
+	default:
+		p.condition.vars = append(p.condition.vars, token)
+		return func(env conditionEnv) string { return env.lookup(token) }, nil
+	}
+}
+
+func (p *conditionParser) parseCall(name string) (conditionExpr, error) {
+	p.pos++
+	if closing, err := p.next(); err != nil || closing != ")" {
+		return nil, fmt.Errorf("%s takes no arguments", name)
+	}
+
+	p.condition.status = true
+	switch name {
+	case "success":
+		return func(env conditionEnv) string {
+			return boolValue(env.needsSucceeded && !env.failed)
+		}, nil
+	case "failure":
+		return func(env conditionEnv) string { return boolValue(env.failed) }, nil
+	case "always":
+		return func(conditionEnv) string { return "true" }, nil
+	default:
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+}
@@ -0,0 +1,57 @@
+package engine
+
+import "testing"
+
+func TestCondition(t *testing.T) {
+	vars := map[string]string{"inputs.target": "prod", "inputs.dry_run": "false", "env.REGION": "eu"}
+	env := conditionEnv{
+		lookup:         func(name string) string { return vars[name] },
+		needsSucceeded: true,
+	}
+	failed := env
+	failed.failed = true
+	skippedNeed := env
+	skippedNeed.needsSucceeded = false
+
+	cases := []struct {
+		text     string
+		env      conditionEnv
+		expected bool
+	}{
+		{"", env, true},
+		{"", failed, false},
+		{"", skippedNeed, false},
+		{"success()", failed, false},
+		{"failure()", env, false},
+		{"failure()", failed, true},
+		{"always()", failed, true},
+		{"always()", skippedNeed, true},
+		{"${{ always() }}", skippedNeed, true},
+		{"inputs.target == 'prod'", env, true},
+		{`inputs.target != "prod"`, env, false},
+		{"inputs.target == 'prod'", failed, false},
+		{"inputs.dry_run", env, false},
+		{"!inputs.dry_run && env.REGION == 'eu'", env, true},
+		{"inputs.missing || env.MISSING", env, false},
+		{"failure() && (inputs.target == 'dev' || env.REGION == 'eu')", failed, true},
+		{"!(failure() || false)", env, true},
+	}
+
+	for _, tc := range cases {
+		cond, err := parseCondition(tc.text)
+		if err != nil {
+			t.Fatalf("%q got error: %v", tc.text, err)
+		}
+		if got := cond.holds(tc.env); got != tc.expected {
+			t.Fatalf("%q got: %v, expected: %v", tc.text, got, tc.expected)
+		}
+	}
+}
+
+func TestConditionInvalid(t *testing.T) {
+	for _, text := range []string{"(", "always(", "inputs.a ==", "'open", "cancelled()", "a b", "a = b", "success(x)"} {
+		if _, err := parseCondition(text); err == nil {
+			t.Fatalf("%q got: no error, expected it to be rejected", text)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"log"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/fourls/soko/internal/crud"
@@ -12,19 +15,65 @@ import (
 type JobEngine struct {
 	Jobs     crud.Crud[JobId, JobInfo]
 	Flows    crud.Crud[FlowId, Flow]
+	cancels  crud.Crud[JobId, context.CancelFunc]
+	index    *jobIndex
+	store    JobStore
+	secrets  SecretStore
+	workers  int
 	jobQueue chan *Job
-	quit     chan bool
+	// dropped takes pending jobs out of the queue once they're cancelled.
+	dropped chan JobId
+	quit    chan bool
+	// saving keeps a job's saves in the same order as its updates.
+	saving *sync.Mutex
 }
 
+type Options struct {
+	// Store persists job history. A nil store keeps it in memory only.
+	Store JobStore
+	// Workers is how many jobs may run at once. Defaults to 1.
+	Workers int
+	// Secrets provides secrets referenced by steps.
+	Secrets SecretStore
+}
+
+// New creates a job engine, restoring job history from the store. Jobs that
+// were pending or running when the store was last written are marked
+// interrupted.
 // todo interfaceize this
-func New() JobEngine {
+func New(options Options) (JobEngine, error) {
 	engine := JobEngine{
 		Jobs:     crud.New[JobId, JobInfo](),
 		Flows:    crud.New[FlowId, Flow](),
+		cancels:  crud.New[JobId, context.CancelFunc](),
+		index:    newJobIndex(),
+		store:    options.Store,
+		saving:   new(sync.Mutex),
+		secrets:  options.Secrets,
+		workers:  max(options.Workers, 1),
 		jobQueue: make(chan *Job, 1024),
+		dropped:  make(chan JobId, 1024),
+		quit:     make(chan bool),
 	}
+
+	if store := options.Store; store != nil {
+		jobs, err := store.Load()
+		if err != nil {
+			return engine, err
+		}
+
+		for id, info := range jobs {
+			if info.State == JobPending || info.State == JobRunning {
+				info.State = JobInterrupted
+				engine.save(id, info)
+			}
+			engine.Jobs.Create(id, info)
+			engine.index.add(id, info)
+		}
+	}
+
 	go engine.worker()
-	return engine
+	return engine, nil
 }
 
 func (s *JobEngine) worker() {
@@ -43,7 +92,43 @@ func (s *JobEngine) Close() {
 	s.quit <- true
 }
 
+// JobOptions are how a job is started.
+type JobOptions struct {
+	// Inputs are values for the flow's inputs. Inputs without a value take
+	// their default.
+	Inputs map[string]string
+	// ScheduledAt is the time the schedule started the job for, if it did.
+	ScheduledAt time.Time
+	// TriggeredBy is the job whose end started this one, if any.
+	TriggeredBy JobId
+}
+
+var ErrFlowNotFound = errors.New("flow not found")
+
+// StartJob queues a job of a flow with the default value of each input. It
+// returns false if the flow does not exist or needs inputs.
 func (s *JobEngine) StartJob(flowId FlowId) (bool, JobId) {
+	jobId, err := s.StartJobWithOptions(flowId, JobOptions{})
+	if err != nil {
+		log.Printf("Failed to start job of %s: %v", flowId, err)
+		return false, ""
+	}
+	return true, jobId
+}
+
+// StartJobWithOptions queues a job of a flow. It returns ErrFlowNotFound if
+// the flow does not exist, or an InputError if the inputs are invalid.
+func (s *JobEngine) StartJobWithOptions(flowId FlowId, options JobOptions) (JobId, error) {
+	flow, ok := s.Flows.Read(flowId)
+	if !ok {
+		return "", ErrFlowNotFound
+	}
+
+	inputs, err := resolveInputs(flow.Inputs, options.Inputs)
+	if err != nil {
+		return "", err
+	}
+
 	jobId := JobId(uuid.New().String())
 
 	job := new(Job)
@@ -51,69 +136,189 @@ func (s *JobEngine) StartJob(flowId FlowId) (bool, JobId) {
 
 	log.Print("Starting job " + job.Id)
 
-	flow, ok := s.Flows.Read(flowId)
+	job.FlowId = flowId
+	job.Steps = flow.Steps
+	job.MaxConcurrent = flow.MaxConcurrent
+	job.Timeout = flow.Timeout
+	job.Retry = flow.Retry
+	job.Inputs = inputs
+	job.Project = flow.Project
+	job.ScheduledAt = options.ScheduledAt
+	job.secrets = s.secrets
+	ctx, cancel := context.WithCancel(context.Background())
+	job.ctx = ctx
+	steps := make([]StepInfo, len(flow.Steps))
+	for i, step := range flow.Steps {
+		steps[i].Id = step.Id
+	}
+	info := JobInfo{
+		FlowId:      flowId,
+		Steps:       steps,
+		Inputs:      inputs,
+		ScheduledAt: options.ScheduledAt,
+		TriggeredBy: options.TriggeredBy,
+		QueuedAt:    time.Now(),
+	}
+	s.saving.Lock()
+	s.Jobs.Create(jobId, info)
+	s.index.add(jobId, info)
+	s.cancels.Create(jobId, cancel)
+	s.save(jobId, info)
+	s.saving.Unlock()
+	s.jobQueue <- job
+	return jobId, nil
+}
+
+// CancelJob stops a pending or running job, killing its current step and
+// skipping the rest. It returns false if the job is not pending or running.
+func (s *JobEngine) CancelJob(id JobId) bool {
+	cancel, ok := s.cancels.Read(id)
 	if !ok {
-		return false, ""
+		return false
+	}
+	// the job may have ended before letting go of its cancel
+	if info, ok := s.Jobs.Read(id); !ok || info.State.Finished() {
+		return false
 	}
 
-	job.Steps = flow.Steps
-	s.Jobs.Create(jobId, JobInfo{
-		FlowId: flowId,
-		Steps:  make([]StepInfo, len(flow.Steps)),
+	log.Printf("Cancelling job %s", id)
+	cancel()
+	pending := false
+	s.updateJob(id, func(info *JobInfo) {
+		if info.State == JobPending {
+			info.State = JobCancelled
+			info.FinishedAt = time.Now()
+			pending = true
+		}
 	})
-	s.jobQueue <- job
-	return true, jobId
+	if pending {
+		s.dropped <- id
+	}
+	return true
+}
+
+// finishJob releases everything held for a job once it can no longer run,
+// and starts the flows its end triggers.
+func (s *JobEngine) finishJob(job *Job) {
+	if cancel, ok := s.cancels.Read(job.Id); ok {
+		cancel()
+		s.cancels.Delete(job.Id)
+	}
+
+	if info, ok := s.Jobs.Read(job.Id); ok {
+		s.startTriggered(job.Id, info)
+	}
 }
 
 func (s *JobEngine) GetJob(id JobId) (JobInfo, bool) {
 	return s.Jobs.Read(id)
 }
 
-func (s *JobEngine) ProcessSchedule(quit chan bool) {
-	lastMinute := time.Now().Minute() - 1
+func (s *JobEngine) save(id JobId, info JobInfo) {
+	if s.store == nil {
+		return
+	}
 
-	for {
-		select {
-		case <-quit:
-			return
-		default:
-			now := time.Now()
-
-			if now.Minute() != lastMinute {
-				lastMinute = now.Minute()
-				flows := s.Flows.Snapshot()
-				for id, flow := range flows {
-					log.Printf("Checking scheduling eligibility for %s", id)
-					if flow.Schedule != nil && scheduleMatches(now, flow.Schedule) {
-						log.Printf("Matches!")
-						s.StartJob(id)
-					}
-				}
-			}
+	if err := s.store.Save(id, info); err != nil {
+		log.Printf("Failed to save job %s: %v", id, err)
+	}
+}
 
-			time.Sleep(20 * time.Second)
-		}
+// updateJob applies an update to a job and writes the result through to the
+// store.
+func (s *JobEngine) updateJob(id JobId, update func(*JobInfo)) {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	if updated, ok := s.progressJob(id, update); ok {
+		s.save(id, updated)
 	}
 }
 
-func scheduleMatches(time time.Time, schedule *FlowSchedule) bool {
-	return (schedule.Days == nil || slices.Contains(schedule.Days, time.Weekday())) &&
-		(schedule.Hours == nil || slices.Contains(schedule.Hours, time.Hour())) &&
-		(schedule.Minutes == nil || slices.Contains(schedule.Minutes, time.Minute()))
+// progressJob applies an update to a job without writing it to the store,
+// for changes too frequent to be worth persisting one by one.
+func (s *JobEngine) progressJob(id JobId, update func(*JobInfo)) (JobInfo, bool) {
+	var updated JobInfo
+	ok := s.Jobs.Update(id, func(info JobInfo) JobInfo {
+		// readers may still hold the old steps
+		info.Steps = slices.Clone(info.Steps)
+		update(&info)
+		updated = info
+		return info
+	})
+	return updated, ok
 }
 
+// RunJobs hands queued jobs to a pool of workers, holding back jobs whose
+// flow already has as many jobs running as it allows.
 func (s *JobEngine) RunJobs(quit chan bool) {
+	work := make(chan *Job)
+	// buffered so workers can always report back, even after quitting
+	done := make(chan *Job, s.workers)
+	for range s.workers {
+		go s.runWorker(work, done)
+	}
+	defer close(work)
+
+	pending := make([]*Job, 0)
+	running := make(map[FlowId]int)
+	idle := s.workers
+
 	for {
+		for i := 0; i < len(pending) && idle > 0; {
+			job := pending[i]
+			if job.ctx.Err() != nil {
+				// cancelled while waiting, so there's nothing to run
+				pending = slices.Delete(pending, i, i+1)
+				s.finishJob(job)
+				continue
+			}
+			if job.MaxConcurrent > 0 && running[job.FlowId] >= job.MaxConcurrent {
+				i++
+				continue
+			}
+
+			pending = slices.Delete(pending, i, i+1)
+			running[job.FlowId]++
+			idle--
+			work <- job
+		}
+
 		select {
 		case <-quit:
 			return
 		case job := <-s.jobQueue:
-			runJob(job, func(updateFunc func(*JobInfo)) {
-				s.Jobs.Update(job.Id, func(info JobInfo) JobInfo {
-					updateFunc(&info)
-					return info
-				})
-			})
+			if job.ctx.Err() != nil {
+				// cancelled before it reached the queue
+				s.finishJob(job)
+				continue
+			}
+			pending = append(pending, job)
+		case id := <-s.dropped:
+			i := slices.IndexFunc(pending, func(job *Job) bool { return job.Id == id })
+			if i >= 0 {
+				job := pending[i]
+				pending = slices.Delete(pending, i, i+1)
+				s.finishJob(job)
+			}
+		case job := <-done:
+			running[job.FlowId]--
+			if running[job.FlowId] == 0 {
+				delete(running, job.FlowId)
+			}
+			idle++
 		}
 	}
 }
+
+func (s *JobEngine) runWorker(work chan *Job, done chan *Job) {
+	for job := range work {
+		runJob(job.ctx, job, func(updateFunc func(*JobInfo)) {
+			s.updateJob(job.Id, updateFunc)
+		}, func(updateFunc func(*JobInfo)) {
+			s.progressJob(job.Id, updateFunc)
+		})
+		s.finishJob(job)
+		done <- job
+	}
+}
@@ -0,0 +1,969 @@
+package engine_test
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fourls/soko/internal/engine"
+)
+
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs map[engine.JobId]engine.JobInfo
+}
+
+func (s *memoryStore) Load() (map[engine.JobId]engine.JobInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make(map[engine.JobId]engine.JobInfo, len(s.jobs))
+	for id, info := range s.jobs {
+		jobs[id] = info
+	}
+	return jobs, nil
+}
+
+func (s *memoryStore) Save(id engine.JobId, info engine.JobInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[id] = info
+	return nil
+}
+
+func (s *memoryStore) Read(id engine.JobId) engine.JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.jobs[id]
+}
+
+func waitForState(t *testing.T, jobEngine *engine.JobEngine, id engine.JobId, state engine.JobState) engine.JobInfo {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info, ok := jobEngine.GetJob(id)
+		if ok && info.State == state {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, _ := jobEngine.GetJob(id)
+	t.Fatalf("got: job state %v, expected: %v", info.State, state)
+	return info
+}
+
+func TestEngineRestoresHistory(t *testing.T) {
+	store := &memoryStore{jobs: map[engine.JobId]engine.JobInfo{
+		"done":    {FlowId: "p.flow", State: engine.JobSucceeded},
+		"running": {FlowId: "p.flow", State: engine.JobRunning},
+		"pending": {FlowId: "p.flow", State: engine.JobPending},
+	}}
+
+	jobEngine, err := engine.New(engine.Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	expected := map[engine.JobId]engine.JobState{
+		"done":    engine.JobSucceeded,
+		"running": engine.JobInterrupted,
+		"pending": engine.JobInterrupted,
+	}
+	for id, state := range expected {
+		info, ok := jobEngine.GetJob(id)
+		if !ok || info.State != state {
+			t.Fatalf("got: %v, %v for job %s, expected: %v", info.State, ok, id, state)
+		}
+		if saved := store.Read(id); saved.State != state {
+			t.Fatalf("got: %v saved for job %s, expected: %v", saved.State, id, state)
+		}
+	}
+}
+
+func TestEngineSavesJobs(t *testing.T) {
+	store := &memoryStore{jobs: make(map[engine.JobId]engine.JobInfo)}
+
+	jobEngine, err := engine.New(engine.Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.flow", engine.Flow{
+		Id:    "p.flow",
+		Steps: []engine.Step{{Args: []string{"echo", "hello"}}},
+	})
+
+	ok, id := jobEngine.StartJob("p.flow")
+	if !ok {
+		t.Fatalf("got: flow not found, expected the job to start")
+	}
+	waitForState(t, &jobEngine, id, engine.JobSucceeded)
+
+	saved := store.Read(id)
+	if saved.State != engine.JobSucceeded || len(saved.Steps) != 1 || saved.Steps[0].Output() != "hello\n" {
+		t.Fatalf("got: %+v saved, expected the finished job", saved)
+	}
+}
+
+func TestEngineRunsFlowsConcurrently(t *testing.T) {
+	release := filepath.Join(t.TempDir(), "release")
+
+	jobEngine, err := engine.New(engine.Options{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.slow", engine.Flow{
+		Id:            "p.slow",
+		Steps:         []engine.Step{{Args: []string{"sh", "-c", "while [ ! -e " + release + " ]; do sleep 0.01; done"}}},
+		MaxConcurrent: 1,
+	})
+	jobEngine.Flows.Create("p.fast", engine.Flow{
+		Id:    "p.fast",
+		Steps: []engine.Step{{Args: []string{"true"}}},
+	})
+
+	_, first := jobEngine.StartJob("p.slow")
+	_, second := jobEngine.StartJob("p.slow")
+	_, fast := jobEngine.StartJob("p.fast")
+
+	waitForState(t, &jobEngine, first, engine.JobRunning)
+	waitForState(t, &jobEngine, fast, engine.JobSucceeded)
+
+	if info, _ := jobEngine.GetJob(second); info.State != engine.JobPending {
+		t.Fatalf("got: %v for second job of the flow, expected it to wait", info.State)
+	}
+
+	if err := os.WriteFile(release, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForState(t, &jobEngine, first, engine.JobSucceeded)
+	waitForState(t, &jobEngine, second, engine.JobSucceeded)
+}
+
+func TestEngineCancelJob(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	jobEngine, err := engine.New(engine.Options{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.flow", engine.Flow{
+		Id: "p.flow",
+		Steps: []engine.Step{
+			{Args: []string{"sleep", "30"}},
+			{Args: []string{"touch", marker}},
+		},
+	})
+	jobEngine.Flows.Create("p.after", engine.Flow{
+		Id:       "p.after",
+		Steps:    []engine.Step{{Args: []string{"true"}}},
+		Triggers: []engine.Trigger{{Flow: "p.flow", When: engine.OnCompletion}},
+	})
+
+	_, running := jobEngine.StartJob("p.flow")
+	_, pending := jobEngine.StartJob("p.flow")
+	waitForState(t, &jobEngine, running, engine.JobRunning)
+
+	if !jobEngine.CancelJob(pending) {
+		t.Fatalf("got: false cancelling pending job, expected: true")
+	}
+	waitForState(t, &jobEngine, pending, engine.JobCancelled)
+	if jobEngine.CancelJob(pending) {
+		t.Fatalf("got: true cancelling cancelled job, expected: false")
+	}
+
+	// the cancelled job ends without waiting for the busy worker
+	triggered := func() bool {
+		return len(jobEngine.Jobs.Filter(func(_ engine.JobId, info engine.JobInfo) bool {
+			return info.TriggeredBy == pending
+		})) > 0
+	}
+	for deadline := time.Now().Add(5 * time.Second); !triggered() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !triggered() {
+		t.Fatalf("got: no job triggered by the cancelled job, expected one")
+	}
+
+	if !jobEngine.CancelJob(running) {
+		t.Fatalf("got: false cancelling running job, expected: true")
+	}
+	waitForState(t, &jobEngine, running, engine.JobCancelled)
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatalf("got: later step ran after cancelling, expected it to be skipped")
+	}
+	if jobEngine.CancelJob(running) {
+		t.Fatalf("got: true cancelling finished job, expected: false")
+	}
+}
+
+func TestEngineTimeouts(t *testing.T) {
+	cases := []struct {
+		name    string
+		flow    engine.Flow
+		message string
+	}{
+		{
+			"step",
+			engine.Flow{Steps: []engine.Step{{Args: []string{"sleep", "30"}, Timeout: 100 * time.Millisecond}}},
+			"Step timed out after 100ms",
+		},
+		{
+			"flow",
+			engine.Flow{
+				Steps:   []engine.Step{{Args: []string{"true"}}, {Args: []string{"sleep", "30"}}},
+				Timeout: 100 * time.Millisecond,
+			},
+			"Flow timed out after 100ms",
+		},
+	}
+
+	jobEngine, err := engine.New(engine.Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			id := engine.FlowId("p." + tc.name)
+			tc.flow.Id = id
+			jobEngine.Flows.Create(id, tc.flow)
+
+			_, jobId := jobEngine.StartJob(id)
+			info := waitForState(t, &jobEngine, jobId, engine.JobTimedOut)
+
+			output := info.Steps[info.CurrentStep].Output()
+			if !strings.HasSuffix(output, tc.message+"\n") {
+				t.Fatalf("got: %q, expected it to end with %q", output, tc.message)
+			}
+		})
+	}
+}
+
+// countingStep fails until it has been run the given number of times.
+func countingStep(t *testing.T, succeedOn int) engine.Step {
+	counter := filepath.Join(t.TempDir(), "counter")
+	script := fmt.Sprintf(`n=$(cat %[1]s 2>/dev/null || echo 0); n=$((n+1)); echo $n > %[1]s; echo attempt $n; [ $n -ge %[2]d ]`, counter, succeedOn)
+	return engine.Step{Args: []string{"sh", "-c", script}}
+}
+
+func TestEngineRetries(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	t.Run("step", func(t *testing.T) {
+		step := countingStep(t, 3)
+		step.Retry = &engine.RetryPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond}
+		jobEngine.Flows.Create("p.step", engine.Flow{Id: "p.step", Steps: []engine.Step{step}})
+
+		_, id := jobEngine.StartJob("p.step")
+		info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+
+		attempts := info.Steps[0].Attempts
+		if len(attempts) != 3 || attempts[0].State != engine.JobFailed || attempts[0].ExitCode != 1 || attempts[2].State != engine.JobSucceeded {
+			t.Fatalf("got: %+v, expected two failures then a success", attempts)
+		}
+
+		for _, line := range info.Steps[0].Lines {
+			if line.Stream == engine.Stdout && line.Text != fmt.Sprintf("attempt %d", line.Attempt+1) {
+				t.Fatalf("got: %q for attempt %d", line.Text, line.Attempt)
+			}
+		}
+	})
+
+	t.Run("exit codes", func(t *testing.T) {
+		step := countingStep(t, 3)
+		step.Retry = &engine.RetryPolicy{MaxAttempts: 3, ExitCodes: []int{75}}
+		jobEngine.Flows.Create("p.codes", engine.Flow{Id: "p.codes", Steps: []engine.Step{step}})
+
+		_, id := jobEngine.StartJob("p.codes")
+		info := waitForState(t, &jobEngine, id, engine.JobFailed)
+
+		if len(info.Steps[0].Attempts) != 1 {
+			t.Fatalf("got: %d attempts, expected no retries for other exit codes", len(info.Steps[0].Attempts))
+		}
+	})
+
+	t.Run("flow", func(t *testing.T) {
+		jobEngine.Flows.Create("p.flow", engine.Flow{
+			Id:    "p.flow",
+			Steps: []engine.Step{{Args: []string{"true"}}, countingStep(t, 2)},
+			Retry: &engine.RetryPolicy{MaxAttempts: 2},
+		})
+
+		_, id := jobEngine.StartJob("p.flow")
+		info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+
+		if len(info.Steps[0].Attempts) != 2 || len(info.Steps[1].Attempts) != 2 {
+			t.Fatalf("got: %+v, expected every step to run twice", info.Steps)
+		}
+	})
+}
+
+type secretMap map[string]string
+
+func (s secretMap) Lookup(name string) (string, bool) {
+	value, ok := s[name]
+	return value, ok
+}
+
+func TestEngineEnvAndSecrets(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{
+		Secrets: secretMap{"TOKEN": "hunter2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.env", engine.Flow{
+		Id: "p.env",
+		Steps: []engine.Step{{
+			Args:    []string{"sh", "-c", `echo "$GREETING"; echo "token is $API_TOKEN"`},
+			Env:     map[string]string{"GREETING": "hello"},
+			Secrets: map[string]string{"API_TOKEN": "TOKEN"},
+		}},
+	})
+	jobEngine.Flows.Create("p.missing", engine.Flow{
+		Id: "p.missing",
+		Steps: []engine.Step{{
+			Args:    []string{"true"},
+			Secrets: map[string]string{"API_TOKEN": "MISSING"},
+		}},
+	})
+
+	_, id := jobEngine.StartJob("p.env")
+	info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	if output := info.Steps[0].Output(); output != "hello\ntoken is ***\n" {
+		t.Fatalf("got: %q, expected the env var and a masked secret", output)
+	}
+
+	_, id = jobEngine.StartJob("p.missing")
+	info = waitForState(t, &jobEngine, id, engine.JobFailed)
+	if output := info.Steps[0].Output(); !strings.Contains(output, "secret MISSING not found") {
+		t.Fatalf("got: %q, expected the missing secret to be reported", output)
+	}
+}
+
+func TestEngineRecordsTimesAndExits(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.killed", engine.Flow{
+		Id: "p.killed",
+		Steps: []engine.Step{
+			{Args: []string{"sleep", "0.05"}},
+			{Args: []string{"sh", "-c", "kill -KILL $$"}},
+		},
+	})
+
+	_, id := jobEngine.StartJob("p.killed")
+	info := waitForState(t, &jobEngine, id, engine.JobFailed)
+
+	if info.QueuedAt.IsZero() || info.StartedAt.Before(info.QueuedAt) || info.FinishedAt.Before(info.StartedAt) {
+		t.Fatalf("got: queued %v, started %v, finished %v, expected them in order", info.QueuedAt, info.StartedAt, info.FinishedAt)
+	}
+	if info.Duration() < 50*time.Millisecond {
+		t.Fatalf("got: %v, expected the job to take at least as long as its steps", info.Duration())
+	}
+
+	first := info.Steps[0]
+	if first.Duration() < 50*time.Millisecond || first.StartedAt.Before(info.StartedAt) {
+		t.Fatalf("got: step started %v and took %v, expected at least 50ms after the job started", first.StartedAt, first.Duration())
+	}
+	if result, ok := first.Result(); !ok || result.ExitCode != 0 || result.Signal != "" {
+		t.Fatalf("got: %+v, expected a clean exit", result)
+	}
+
+	second := info.Steps[1]
+	if second.StartedAt.Before(first.FinishedAt) {
+		t.Fatalf("got: second step started %v, expected after the first finished at %v", second.StartedAt, first.FinishedAt)
+	}
+	if result, ok := second.Result(); !ok || result.ExitCode != -1 || result.Signal != "killed" {
+		t.Fatalf("got: %+v, expected the step to be killed", result)
+	}
+}
+
+func TestEngineQueryJobs(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryStore{jobs: make(map[engine.JobId]engine.JobInfo)}
+	for i, flow := range []engine.FlowId{"p.a", "p.b", "p.a", "p.a", "p.b"} {
+		state := engine.JobSucceeded
+		if i == 2 {
+			state = engine.JobFailed
+		}
+		store.jobs[engine.JobId(fmt.Sprintf("job%d", i))] = engine.JobInfo{
+			FlowId:    flow,
+			State:     state,
+			StartedAt: base.Add(time.Duration(i) * time.Hour),
+		}
+	}
+
+	jobEngine, err := engine.New(engine.Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	ids := func(entries []engine.JobEntry) []engine.JobId {
+		res := make([]engine.JobId, len(entries))
+		for i, entry := range entries {
+			res[i] = entry.Id
+		}
+		return res
+	}
+
+	cases := []struct {
+		query    engine.JobQuery
+		expected []engine.JobId
+	}{
+		{engine.JobQuery{}, []engine.JobId{"job4", "job3", "job2", "job1", "job0"}},
+		{engine.JobQuery{Ascending: true}, []engine.JobId{"job0", "job1", "job2", "job3", "job4"}},
+		{engine.JobQuery{FlowId: "p.a"}, []engine.JobId{"job3", "job2", "job0"}},
+		{engine.JobQuery{States: []engine.JobState{engine.JobFailed}}, []engine.JobId{"job2"}},
+		{engine.JobQuery{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, []engine.JobId{"job2", "job1"}},
+	}
+	for _, tc := range cases {
+		entries, next, err := jobEngine.QueryJobs(tc.query)
+		if err != nil || next != "" {
+			t.Fatalf("got: %v, %q, expected a single page", err, next)
+		}
+		if got := ids(entries); !slices.Equal(got, tc.expected) {
+			t.Fatalf("%+v got: %v, expected: %v", tc.query, got, tc.expected)
+		}
+	}
+
+	query := engine.JobQuery{Limit: 2}
+	pages := make([][]engine.JobId, 0)
+	for {
+		entries, next, err := jobEngine.QueryJobs(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, ids(entries))
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	if fmt.Sprint(pages) != "[[job4 job3] [job2 job1] [job0]]" {
+		t.Fatalf("got: %v, expected three pages of newest jobs first", pages)
+	}
+
+	if _, _, err := jobEngine.QueryJobs(engine.JobQuery{Cursor: "nonsense!"}); !errors.Is(err, engine.ErrInvalidCursor) {
+		t.Fatalf("got: %v, expected: %v", err, engine.ErrInvalidCursor)
+	}
+
+	if last, ok := jobEngine.LastJob("p.a"); !ok || last.Id != "job3" {
+		t.Fatalf("got: %v, expected: job3", last.Id)
+	}
+	if _, ok := jobEngine.LastJob("p.none"); ok {
+		t.Fatalf("got: a last job for a flow that never ran")
+	}
+}
+
+func TestEngineQueryJobsPages(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryStore{jobs: make(map[engine.JobId]engine.JobInfo)}
+	for i := range 300 {
+		flow := engine.FlowId("p.a")
+		if i%3 == 0 {
+			flow = "p.b"
+		}
+		store.jobs[engine.JobId(fmt.Sprintf("job%03d", i))] = engine.JobInfo{
+			FlowId:   flow,
+			State:    engine.JobSucceeded,
+			QueuedAt: base.Add(time.Duration(i/2) * time.Minute),
+		}
+	}
+
+	jobEngine, err := engine.New(engine.Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	for _, ascending := range []bool{true, false} {
+		expected := make([]engine.JobId, 0)
+		for i := 20; i < 260; i++ {
+			if i%3 != 0 {
+				expected = append(expected, engine.JobId(fmt.Sprintf("job%03d", i)))
+			}
+		}
+		if !ascending {
+			slices.Reverse(expected)
+		}
+
+		query := engine.JobQuery{
+			FlowId:    "p.a",
+			Since:     base.Add(10 * time.Minute),
+			Until:     base.Add(130 * time.Minute),
+			Ascending: ascending,
+			Limit:     25,
+		}
+		got := make([]engine.JobId, 0)
+		for {
+			entries, next, err := jobEngine.QueryJobs(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				got = append(got, entry.Id)
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+		if !slices.Equal(got, expected) {
+			t.Fatalf("ascending %v got: %v, expected: %v", ascending, got, expected)
+		}
+	}
+}
+
+func TestEngineInputs(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	staging := "staging"
+	jobEngine.Flows.Create("p.deploy", engine.Flow{
+		Id: "p.deploy",
+		Inputs: []engine.Input{
+			{Name: "target", Type: engine.InputChoice, Default: &staging, Options: []string{"staging", "production"}},
+			{Name: "dry_run", Type: engine.InputBool},
+			{Name: "version", Type: engine.InputString},
+		},
+		Steps: []engine.Step{{
+			Args: []string{"sh", "-c", `echo "${{ inputs.version }} to ${{ inputs.target }} $DRY_RUN"`},
+			Env:  map[string]string{"DRY_RUN": "dry=${{ inputs.dry_run }}"},
+		}},
+	})
+
+	invalid := []map[string]string{
+		{"dry_run": "true"},
+		{"dry_run": "maybe", "version": "1.0"},
+		{"dry_run": "true", "version": "1.0", "target": "moon"},
+		{"dry_run": "true", "version": "1.0", "colour": "blue"},
+	}
+	for _, inputs := range invalid {
+		_, err := jobEngine.StartJobWithOptions("p.deploy", engine.JobOptions{Inputs: inputs})
+		var inputErr *engine.InputError
+		if !errors.As(err, &inputErr) {
+			t.Fatalf("%v got: %v, expected an input error", inputs, err)
+		}
+	}
+
+	if _, err := jobEngine.StartJobWithOptions("p.missing", engine.JobOptions{}); !errors.Is(err, engine.ErrFlowNotFound) {
+		t.Fatalf("got: %v, expected: %v", err, engine.ErrFlowNotFound)
+	}
+
+	id, err := jobEngine.StartJobWithOptions("p.deploy", engine.JobOptions{
+		Inputs: map[string]string{"dry_run": "1", "version": "1.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	if output := info.Steps[0].Output(); output != "1.0 to staging dry=true\n" {
+		t.Fatalf("got: %q, expected the inputs to be substituted", output)
+	}
+	expected := map[string]string{"target": "staging", "dry_run": "true", "version": "1.0"}
+	if !maps.Equal(info.Inputs, expected) {
+		t.Fatalf("got: %v, expected: %v", info.Inputs, expected)
+	}
+}
+
+func TestEngineTemplateVars(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.vars", engine.Flow{
+		Id:      "p.vars",
+		Project: "p",
+		Steps: []engine.Step{
+			{Args: []string{"echo", "${{ project.name }}/${{ flow.id }}/${{ job.id }}@${{ job.scheduled_time }}"}},
+			{Args: []string{"sh", "-c", `echo "$PREVIOUS"`}, Env: map[string]string{"PREVIOUS": "got ${{ steps.1.output }}"}},
+		},
+	})
+
+	scheduled := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	id, err := jobEngine.StartJobWithOptions("p.vars", engine.JobOptions{ScheduledAt: scheduled})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	expected := fmt.Sprintf("p/p.vars/%s@2024-05-01T09:30:00Z", id)
+	if output := info.Steps[0].Output(); output != expected+"\n" {
+		t.Fatalf("got: %q, expected: %q", output, expected+"\n")
+	}
+	if input := info.Steps[1].Input; input != "sh -c echo \"$PREVIOUS\"" {
+		t.Fatalf("got: %q, expected the step's input to be recorded", input)
+	}
+	if output := info.Steps[1].Output(); output != "got "+expected+"\n" {
+		t.Fatalf("got: %q, expected the first step's output", output)
+	}
+	if !info.ScheduledAt.Equal(scheduled) {
+		t.Fatalf("got: %v, expected: %v", info.ScheduledAt, scheduled)
+	}
+}
+
+func TestEngineStepOutputs(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{
+		Secrets: secretMap{"TOKEN": "hunter2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.outputs", engine.Flow{
+		Id: "p.outputs",
+		Steps: []engine.Step{
+			{
+				Args: []string{"sh", "-c", `echo building
+echo "::set-output name=version::1.2.3"
+echo "::set-output name=notes::line one%0Aline two"
+echo "::set-output name=token::$TOKEN"
+echo "::set-output name=other::ignored"`},
+				Secrets: map[string]string{"TOKEN": "TOKEN"},
+				Outputs: []string{"version", "notes", "token", "unset"},
+			},
+			{
+				Args: []string{"sh", "-c", `echo "v$VERSION"; echo "$NOTES"; echo "[$UNSET]"`},
+				Env: map[string]string{
+					"VERSION": "${{ steps.1.outputs.version }}",
+					"NOTES":   "${{ steps.1.outputs.notes }}",
+					"UNSET":   "${{ steps.1.outputs.unset }}",
+				},
+			},
+		},
+	})
+
+	_, id := jobEngine.StartJob("p.outputs")
+	info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+
+	first := info.Steps[0]
+	expected := map[string]string{"version": "1.2.3", "notes": "line one\nline two", "token": "***"}
+	if !maps.Equal(first.Outputs, expected) {
+		t.Fatalf("got: %v, expected: %v", first.Outputs, expected)
+	}
+	if output := first.Output(); output != "building\nIgnoring output \"other\", which the step does not declare\n" {
+		t.Fatalf("got: %q, expected output lines to be hidden", output)
+	}
+
+	if output := info.Steps[1].Output(); output != "v1.2.3\nline one\nline two\n[]\n" {
+		t.Fatalf("got: %q, expected the outputs of the first step", output)
+	}
+}
+
+func TestEngineStepGraph(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	// lint and test each wait for the other to start, so they only succeed
+	// if they run in parallel
+	dir := t.TempDir()
+	rendezvous := func(mine string, theirs string) []string {
+		script := fmt.Sprintf(`touch %[1]s; for i in $(seq 100); do [ -e %[2]s ] && exit 0; sleep 0.05; done; exit 1`,
+			filepath.Join(dir, mine), filepath.Join(dir, theirs))
+		return []string{"sh", "-c", script}
+	}
+
+	jobEngine.Flows.Create("p.build", engine.Flow{
+		Id: "p.build",
+		Steps: []engine.Step{
+			{Id: "build", Args: []string{"true"}},
+			{Id: "lint", Args: rendezvous("lint", "test"), Needs: []int{0}},
+			{Id: "test", Args: rendezvous("test", "lint"), Needs: []int{0}},
+			{Id: "package", Args: []string{"true"}, Needs: []int{1, 2}},
+		},
+	})
+	jobEngine.Flows.Create("p.broken", engine.Flow{
+		Id: "p.broken",
+		Steps: []engine.Step{
+			{Id: "lint", Args: []string{"false"}, Needs: []int{}},
+			{Id: "test", Args: []string{"sh", "-c", "sleep 0.2"}, Needs: []int{}},
+			{Id: "package", Args: []string{"true"}, Needs: []int{0, 1}},
+		},
+	})
+
+	_, id := jobEngine.StartJob("p.build")
+	info := waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	for _, step := range info.Steps {
+		if step.State != engine.JobSucceeded {
+			t.Fatalf("got: %s %s, expected every step to succeed", step.Id, step.State)
+		}
+	}
+	if packaged := info.Steps[3].StartedAt; packaged.Before(info.Steps[1].FinishedAt) || packaged.Before(info.Steps[2].FinishedAt) {
+		t.Fatalf("got: package started at %v, expected it to wait for lint and test", packaged)
+	}
+
+	_, id = jobEngine.StartJob("p.broken")
+	info = waitForState(t, &jobEngine, id, engine.JobFailed)
+	states := make([]engine.JobState, len(info.Steps))
+	for i, step := range info.Steps {
+		states[i] = step.State
+	}
+	expected := []engine.JobState{engine.JobFailed, engine.JobSucceeded, engine.JobSkipped}
+	if !slices.Equal(states, expected) {
+		t.Fatalf("got: %v, expected: %v", states, expected)
+	}
+}
+
+func TestEngineTriggers(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	flow := func(id engine.FlowId, args []string, triggers ...engine.Trigger) {
+		jobEngine.Flows.Create(id, engine.Flow{
+			Id:       id,
+			Steps:    []engine.Step{{Args: args}},
+			Triggers: triggers,
+		})
+	}
+	flow("p.build", []string{"true"})
+	flow("p.broken", []string{"false"})
+	flow("p.deploy", []string{"true"}, engine.Trigger{Flow: "p.build", When: engine.OnSuccess})
+	flow("p.alert", []string{"true"},
+		engine.Trigger{Flow: "p.build", When: engine.OnFailure},
+		engine.Trigger{Flow: "p.broken", When: engine.OnFailure})
+	flow("p.tidy", []string{"true"}, engine.Trigger{Flow: "p.broken", When: engine.OnCompletion})
+	// ping and pong trigger each other, but each only runs once per chain
+	flow("p.ping", []string{"true"}, engine.Trigger{Flow: "p.pong", When: engine.OnSuccess})
+	flow("p.pong", []string{"true"}, engine.Trigger{Flow: "p.ping", When: engine.OnSuccess})
+
+	triggered := func(by engine.JobId) []engine.FlowId {
+		t.Helper()
+
+		// give triggered jobs time to start
+		time.Sleep(200 * time.Millisecond)
+		flows := make([]engine.FlowId, 0)
+		for id, info := range jobEngine.Jobs.Snapshot() {
+			if info.TriggeredBy == by {
+				waitForState(t, &jobEngine, id, engine.JobSucceeded)
+				flows = append(flows, info.FlowId)
+			}
+		}
+		slices.Sort(flows)
+		return flows
+	}
+
+	_, id := jobEngine.StartJob("p.build")
+	waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	if got := triggered(id); !slices.Equal(got, []engine.FlowId{"p.deploy"}) {
+		t.Fatalf("got: %v triggered by build, expected: [p.deploy]", got)
+	}
+
+	_, id = jobEngine.StartJob("p.broken")
+	waitForState(t, &jobEngine, id, engine.JobFailed)
+	if got := triggered(id); !slices.Equal(got, []engine.FlowId{"p.alert", "p.tidy"}) {
+		t.Fatalf("got: %v triggered by broken, expected: [p.alert p.tidy]", got)
+	}
+
+	_, id = jobEngine.StartJob("p.ping")
+	waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	pong := triggered(id)
+	if !slices.Equal(pong, []engine.FlowId{"p.pong"}) {
+		t.Fatalf("got: %v triggered by ping, expected: [p.pong]", pong)
+	}
+	for pongId, info := range jobEngine.Jobs.Snapshot() {
+		if info.FlowId == "p.pong" {
+			if got := triggered(pongId); len(got) != 0 {
+				t.Fatalf("got: %v triggered by pong, expected the chain to stop", got)
+			}
+		}
+	}
+}
+
+func TestEngineStepConditions(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	notify := "true"
+	jobEngine.Flows.Create("p.deploy", engine.Flow{
+		Id:     "p.deploy",
+		Inputs: []engine.Input{{Name: "notify", Type: engine.InputBool, Default: &notify}},
+		Steps: []engine.Step{
+			{Id: "lint", Args: []string{"false"}, ContinueOnError: true},
+			{Id: "deploy", Args: []string{"sh", "-c", "echo ::set-output name=error::disk full; exit 1"}, Outputs: []string{"error"}},
+			{Id: "verify", Args: []string{"true"}},
+			{Id: "alert", Args: []string{"echo", "${{ steps.deploy.outputs.error }}"}, Needs: []int{1}, If: "failure() && inputs.notify"},
+			{Id: "quiet", Args: []string{"true"}, Needs: []int{1}, If: "failure() && !inputs.notify"},
+			{Id: "teardown", Args: []string{"true"}, Needs: []int{2}, If: "always()"},
+		},
+	})
+
+	_, id := jobEngine.StartJob("p.deploy")
+	info := waitForState(t, &jobEngine, id, engine.JobFailed)
+
+	states := make([]engine.JobState, len(info.Steps))
+	for i, step := range info.Steps {
+		states[i] = step.State
+	}
+	expected := []engine.JobState{
+		engine.JobFailed, engine.JobFailed, engine.JobSkipped,
+		engine.JobSucceeded, engine.JobSkipped, engine.JobSucceeded,
+	}
+	if !slices.Equal(states, expected) {
+		t.Fatalf("got: %v, expected: %v", states, expected)
+	}
+	if output := info.Steps[3].Output(); output != "disk full\n" {
+		t.Fatalf("got: %q, expected the alert to see the failed step's output", output)
+	}
+
+	// a step that may fail doesn't fail the job
+	jobEngine.Flows.Create("p.lint", engine.Flow{
+		Id: "p.lint",
+		Steps: []engine.Step{
+			{Args: []string{"false"}, ContinueOnError: true},
+			{Args: []string{"true"}},
+		},
+	})
+
+	_, id = jobEngine.StartJob("p.lint")
+	info = waitForState(t, &jobEngine, id, engine.JobSucceeded)
+	if state := info.Steps[1].State; state != engine.JobSucceeded {
+		t.Fatalf("got: %v, expected the step after the allowed failure to run", state)
+	}
+}
+
+func TestEngineTeardownSteps(t *testing.T) {
+	jobEngine, err := engine.New(engine.Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	steps := func(dir string) []engine.Step {
+		return []engine.Step{
+			{Args: []string{"sleep", "30"}},
+			{Args: []string{"touch", filepath.Join(dir, "next")}},
+			{Args: []string{"touch", filepath.Join(dir, "cleanup")}, If: "always()"},
+			{Args: []string{"touch", filepath.Join(dir, "report")}, If: "failure()"},
+		}
+	}
+	check := func(t *testing.T, info engine.JobInfo, dir string) {
+		states := make([]engine.JobState, len(info.Steps))
+		for i, step := range info.Steps {
+			states[i] = step.State
+		}
+		if states[1] != engine.JobSkipped || states[2] != engine.JobSucceeded || states[3] != engine.JobSucceeded {
+			t.Fatalf("got: %v, expected only the always() and failure() steps to run", states)
+		}
+		for _, name := range []string{"cleanup", "report"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Fatalf("got: %v, expected the %s step to run", err, name)
+			}
+		}
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		dir := t.TempDir()
+		jobEngine.Flows.Create("p.timeout", engine.Flow{Id: "p.timeout", Steps: steps(dir), Timeout: 100 * time.Millisecond})
+
+		_, id := jobEngine.StartJob("p.timeout")
+		check(t, waitForState(t, &jobEngine, id, engine.JobTimedOut), dir)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		dir := t.TempDir()
+		jobEngine.Flows.Create("p.cancel", engine.Flow{Id: "p.cancel", Steps: steps(dir)})
+
+		_, id := jobEngine.StartJob("p.cancel")
+		waitForState(t, &jobEngine, id, engine.JobRunning)
+		jobEngine.CancelJob(id)
+		check(t, waitForState(t, &jobEngine, id, engine.JobCancelled), dir)
+	})
+}
+
+func TestEngineSchedulesFlowsAsTheyChange(t *testing.T) {
+	// the flow last ran a while ago, before the daemon went down
+	lastRun := time.Now().Truncate(time.Minute).Add(-5 * time.Minute)
+	store := &memoryStore{jobs: map[engine.JobId]engine.JobInfo{
+		"earlier": {FlowId: "p.every", State: engine.JobSucceeded, ScheduledAt: lastRun},
+	}}
+
+	jobEngine, err := engine.New(engine.Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+
+	jobEngine.Flows.Create("p.every", engine.Flow{
+		Id:       "p.every",
+		Steps:    []engine.Step{{Args: []string{"true"}}},
+		Schedule: &engine.FlowSchedule{},
+		Catchup:  engine.Catchup{Policy: engine.CatchupOnce},
+	})
+
+	scheduled := func() []time.Time {
+		times := make([]time.Time, 0)
+		for id, info := range jobEngine.Jobs.Snapshot() {
+			if id != "earlier" && !info.ScheduledAt.IsZero() {
+				times = append(times, info.ScheduledAt)
+			}
+		}
+		return times
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(scheduled()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the missed runs are caught up with a single job, though the minute may
+	// have turned since
+	time.Sleep(200 * time.Millisecond)
+	times := scheduled()
+	if len(times) == 0 || len(times) > 2 {
+		t.Fatalf("got: jobs scheduled for %v, expected one for the latest missed run", times)
+	}
+	for _, at := range times {
+		if !at.After(lastRun.Add(4 * time.Minute)) {
+			t.Fatalf("got: job scheduled for %v, expected only the latest missed run", at)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// SecretStore provides the values of secrets referenced by steps.
+type SecretStore interface {
+	Lookup(name string) (string, bool)
+}
+
+// masker hides secret values in text that is stored or served.
+type masker struct {
+	replacer *strings.Replacer
+}
+
+const secretMask = "***"
+
+func newMasker(values []string) masker {
+	pairs := make([]string, 0, len(values)*2)
+	// replace longer values first, in case one secret contains another
+	slices.SortFunc(values, func(a, b string) int { return len(b) - len(a) })
+	for _, value := range values {
+		if value != "" {
+			pairs = append(pairs, value, secretMask)
+		}
+	}
+
+	if len(pairs) == 0 {
+		return masker{}
+	}
+	return masker{strings.NewReplacer(pairs...)}
+}
+
+func (m masker) mask(text string) string {
+	if m.replacer == nil {
+		return text
+	}
+	return m.replacer.Replace(text)
+}
+
+// lookupSecrets finds the value of every secret each step of a job uses,
+// keyed by the environment variable it is set to. It also returns every
+// value found, so they can be masked.
+func lookupSecrets(steps []Step, secrets SecretStore) ([]map[string]string, []string, error) {
+	res := make([]map[string]string, len(steps))
+	values := make([]string, 0)
+
+	for i, step := range steps {
+		for _, key := range slices.Sorted(maps.Keys(step.Secrets)) {
+			name := step.Secrets[key]
+
+			var value string
+			ok := false
+			if secrets != nil {
+				value, ok = secrets.Lookup(name)
+			}
+			if !ok {
+				return nil, nil, fmt.Errorf("secret %s not found", name)
+			}
+
+			if res[i] == nil {
+				res[i] = make(map[string]string)
+			}
+			res[i][key] = value
+			values = append(values, value)
+		}
+	}
+
+	return res, values, nil
+}
+
+// stepEnv builds the environment for a step from the daemon's environment,
+// the step's variables and the values of its secrets. It returns nil if the
+// step adds nothing, so it inherits the daemon's environment.
+func stepEnv(step Step, secrets map[string]string) []string {
+	if len(step.Env) == 0 && len(secrets) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	for _, key := range slices.Sorted(maps.Keys(step.Env)) {
+		env = append(env, key+"="+step.Env[key])
+	}
+	for _, key := range slices.Sorted(maps.Keys(secrets)) {
+		env = append(env, key+"="+secrets[key])
+	}
+	return env
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// stepNeeds returns the indices of the steps that must succeed before step i
+// of steps runs.
+func stepNeeds(steps []Step, i int) []int {
+	if steps[i].Needs == nil && i > 0 {
+		return []int{i - 1}
+	}
+	return steps[i].Needs
+}
+
+// StepNeeds returns the indices of the steps that must succeed before step
+// i of the flow runs.
+func (f Flow) StepNeeds(i int) []int {
+	return stepNeeds(f.Steps, i)
+}
+
+func stepName(steps []Step, i int) string {
+	if steps[i].Id != "" {
+		return steps[i].Id
+	}
+	return fmt.Sprintf("step %d", i+1)
+}
+
+// checkGraph checks that every step only needs steps that exist, and that no
+// step needs itself, even indirectly.
+func checkGraph(steps []Step) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(steps))
+	path := make([]int, 0, len(steps))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visited:
+			return nil
+		case visiting:
+			start := slices.Index(path, i)
+			names := make([]string, 0, len(path)-start+1)
+			for _, j := range append(path[start:], i) {
+				names = append(names, stepName(steps, j))
+			}
+			return fmt.Errorf("steps form a cycle: %s", strings.Join(names, " -> "))
+		}
+
+		marks[i] = visiting
+		path = append(path, i)
+		for _, need := range stepNeeds(steps, i) {
+			if need < 0 || need >= len(steps) {
+				return fmt.Errorf("%s needs step %d, which does not exist", stepName(steps, i), need+1)
+			}
+			if err := visit(need); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[i] = visited
+		return nil
+	}
+
+	for i := range steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ancestors returns the steps that must succeed before step i runs, directly
+// or indirectly. The steps must have passed checkGraph.
+func ancestors(steps []Step, i int) []int {
+	seen := make(map[int]bool)
+	queue := slices.Clone(stepNeeds(steps, i))
+	for len(queue) > 0 {
+		j := queue[0]
+		queue = queue[1:]
+		if !seen[j] {
+			seen[j] = true
+			queue = append(queue, stepNeeds(steps, j)...)
+		}
+	}
+
+	res := make([]int, 0, len(seen))
+	for j := range seen {
+		res = append(res, j)
+	}
+	slices.Sort(res)
+	return res
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type InputType int
+
+const (
+	InputString InputType = iota
+	InputBool
+	InputChoice
+)
+
+func (t InputType) String() string {
+	switch t {
+	case InputString:
+		return "string"
+	case InputBool:
+		return "bool"
+	case InputChoice:
+		return "choice"
+	default:
+		return "unknown"
+	}
+}
+
+// Input is a value a flow takes each time it runs.
+type Input struct {
+	Name        string
+	Type        InputType
+	Description string
+	// Default is used when no value is given. If it is nil, a value must be
+	// given.
+	Default *string
+	// Options are the values an InputChoice may take.
+	Options []string
+}
+
+// InputError describes a problem with the inputs a job was started with.
+type InputError struct {
+	Input string
+	Err   error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("input %s: %v", e.Input, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// check validates a value given for the input, returning it in canonical
+// form.
+func (i Input) check(value string) (string, error) {
+	switch i.Type {
+	case InputBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not true or false", value)
+		}
+		return strconv.FormatBool(b), nil
+	case InputChoice:
+		if !slices.Contains(i.Options, value) {
+			return "", fmt.Errorf("%q is not one of %s", value, strings.Join(i.Options, ", "))
+		}
+	}
+	return value, nil
+}
+
+// resolveInputs checks the values given for a flow's inputs, filling in
+// defaults for those not given.
+func resolveInputs(inputs []Input, given map[string]string) (map[string]string, error) {
+	res := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		value, ok := given[input.Name]
+		if !ok {
+			if input.Default == nil {
+				return nil, &InputError{input.Name, fmt.Errorf("a value is required")}
+			}
+			value = *input.Default
+		}
+
+		value, err := input.check(value)
+		if err != nil {
+			return nil, &InputError{input.Name, err}
+		}
+		res[input.Name] = value
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(given)) {
+		if _, ok := res[name]; !ok {
+			return nil, &InputError{name, fmt.Errorf("the flow has no such input")}
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bytes"
+	"time"
+)
+
+// maxLineLength bounds how much output is buffered waiting for a newline
+// before it is emitted as a line anyway.
+const maxLineLength = 64 * 1024
+
+// lineWriter splits one output stream of a step into timestamped lines. It
+// is not safe for concurrent use, so each stream needs its own.
+type lineWriter struct {
+	stream  OutputStream
+	emit    func(OutputLine)
+	pending []byte
+	started time.Time
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		if len(w.pending) == 0 {
+			w.started = time.Now()
+		}
+
+		room := maxLineLength - len(w.pending)
+		i := bytes.IndexByte(p, '\n')
+		switch {
+		case i >= 0 && i <= room:
+			w.pending = append(w.pending, p[:i]...)
+			w.emitLine()
+			p = p[i+1:]
+		case len(p) < room:
+			w.pending = append(w.pending, p...)
+			p = nil
+		default:
+			w.pending = append(w.pending, p[:room]...)
+			w.emitLine()
+			p = p[room:]
+		}
+	}
+
+	return n, nil
+}
+
+func (w *lineWriter) emitLine() {
+	w.emit(OutputLine{
+		Time:   w.started,
+		Stream: w.stream,
+		Text:   string(bytes.TrimSuffix(w.pending, []byte{'\r'})),
+	})
+	w.pending = w.pending[:0]
+}
+
+// Flush emits any output left without a trailing newline.
+func (w *lineWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.emitLine()
+	}
+}
@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	lines := make([]string, 0)
+	w := &lineWriter{stream: Stderr, emit: func(line OutputLine) {
+		if line.Stream != Stderr || line.Time.IsZero() {
+			t.Fatalf("got: %+v, expected a timestamped stderr line", line)
+		}
+		lines = append(lines, line.Text)
+	}}
+
+	for _, chunk := range []string{"one\ntw", "o\r\n", "\nthree", "", " four"} {
+		w.Write([]byte(chunk))
+	}
+	w.Flush()
+
+	expected := []string{"one", "two", "", "three four"}
+	if !slices.Equal(lines, expected) {
+		t.Fatalf("got: %q, expected: %q", lines, expected)
+	}
+
+	lines = lines[:0]
+	w.Write([]byte(strings.Repeat("x", maxLineLength+10)))
+	w.Flush()
+	if len(lines) != 2 || len(lines[0]) != maxLineLength {
+		t.Fatalf("got: %d lines, expected a long line to be split", len(lines))
+	}
+}
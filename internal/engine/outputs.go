@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// setOutputPrefix starts lines a step writes to its standard output to set
+// one of its outputs, in the form ::set-output name=<name>::<value>.
+const setOutputPrefix = "::set-output name="
+
+// outputEscapes decodes the escapes that let output values span lines.
+var outputEscapes = strings.NewReplacer("%0A", "\n", "%0D", "\r", "%25", "%")
+
+// parseSetOutput parses a line setting an output, reporting whether it is
+// one.
+func parseSetOutput(text string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(text, setOutputPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	name, value, ok := strings.Cut(rest, "::")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(name), outputEscapes.Replace(value), true
+}
+
+// stepVars returns the prefixes the variables of a step are found under: its
+// number, counting from 1, and its id if it has one.
+func stepVars(steps []Step, i int) []string {
+	prefixes := []string{fmt.Sprintf("steps.%d", i+1)}
+	if steps[i].Id != "" {
+		prefixes = append(prefixes, "steps."+steps[i].Id)
+	}
+	return prefixes
+}
+
+// setStepVars sets the variables for a step's standard output and outputs.
+func setStepVars(vars map[string]string, steps []Step, i int, output string, outputs map[string]string) {
+	for _, prefix := range stepVars(steps, i) {
+		vars[prefix+".output"] = output
+		for _, name := range steps[i].Outputs {
+			vars[prefix+".outputs."+name] = outputs[name]
+		}
+	}
+}
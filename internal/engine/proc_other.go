@@ -0,0 +1,23 @@
+//go:build !unix
+
+package engine
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// without process groups or SIGTERM, cancelling kills the step outright
+func terminate(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func processSignal(state *os.ProcessState) string {
+	return ""
+}
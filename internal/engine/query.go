@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobQuery selects jobs to list. Zero fields match every job.
+type JobQuery struct {
+	FlowId FlowId
+	// States matches jobs in any of the given states.
+	States []JobState
+	// Since and Until bound when jobs were queued, inclusively and
+	// exclusively.
+	Since time.Time
+	Until time.Time
+	// Ascending lists the oldest jobs first instead of the newest.
+	Ascending bool
+	// Limit caps how many jobs are returned, or is 0 for no limit.
+	Limit int
+	// Cursor continues a previous query from where it left off.
+	Cursor string
+}
+
+type JobEntry struct {
+	Id   JobId
+	Info JobInfo
+}
+
+// jobKey orders jobs by when they were queued. It doesn't
+// change once a job is queued, so pages don't shift as jobs run.
+type jobKey struct {
+	time time.Time
+	id   JobId
+}
+
+func keyOf(id JobId, info JobInfo) jobKey {
+	started := info.QueuedAt
+	if started.IsZero() {
+		// recorded before jobs kept when they were queued
+		started = info.StartedAt
+	}
+	return jobKey{started, id}
+}
+
+func (k jobKey) compare(other jobKey) int {
+	if c := k.time.Compare(other.time); c != 0 {
+		return c
+	}
+	return cmp.Compare(k.id, other.id)
+}
+
+func (k jobKey) cursor() string {
+	value := strconv.FormatInt(k.time.UnixNano(), 10) + ":" + string(k.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func parseCursor(cursor string) (jobKey, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return jobKey{}, ErrInvalidCursor
+	}
+
+	nanos, id, ok := strings.Cut(string(value), ":")
+	if !ok {
+		return jobKey{}, ErrInvalidCursor
+	}
+	unix, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return jobKey{}, ErrInvalidCursor
+	}
+	return jobKey{time.Unix(0, unix), JobId(id)}, nil
+}
+
+func (q JobQuery) matches(key jobKey, info JobInfo) bool {
+	return (q.FlowId == "" || info.FlowId == q.FlowId) &&
+		(len(q.States) == 0 || slices.Contains(q.States, info.State)) &&
+		(q.Since.IsZero() || !key.time.Before(q.Since)) &&
+		(q.Until.IsZero() || key.time.Before(q.Until))
+}
+
+// jobIndex keeps the keys of jobs in order, of every job and of each flow's,
+// so queries can seek to where they start rather than sorting every job.
+type jobIndex struct {
+	mu     sync.RWMutex
+	all    []jobKey
+	byFlow map[FlowId][]jobKey
+}
+
+func newJobIndex() *jobIndex {
+	return &jobIndex{byFlow: make(map[FlowId][]jobKey)}
+}
+
+func insertKey(keys []jobKey, key jobKey) []jobKey {
+	// jobs are almost always queued after every other
+	if len(keys) == 0 || keys[len(keys)-1].compare(key) < 0 {
+		return append(keys, key)
+	}
+	i, _ := slices.BinarySearchFunc(keys, key, jobKey.compare)
+	return slices.Insert(keys, i, key)
+}
+
+func (x *jobIndex) add(id JobId, info JobInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	key := keyOf(id, info)
+	x.all = insertKey(x.all, key)
+	x.byFlow[info.FlowId] = insertKey(x.byFlow[info.FlowId], key)
+}
+
+// next returns up to n keys of a flow's jobs, or of every job if flowId is
+// empty, that follow from in the given direction. A nil from starts at the
+// first key in that direction.
+func (x *jobIndex) next(flowId FlowId, from *jobKey, ascending bool, n int) []jobKey {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	keys := x.all
+	if flowId != "" {
+		keys = x.byFlow[flowId]
+	}
+
+	if ascending {
+		i := 0
+		if from != nil {
+			var found bool
+			if i, found = slices.BinarySearchFunc(keys, *from, jobKey.compare); found {
+				i++
+			}
+		}
+		return slices.Clone(keys[i:min(i+n, len(keys))])
+	}
+
+	j := len(keys)
+	if from != nil {
+		j, _ = slices.BinarySearchFunc(keys, *from, jobKey.compare)
+	}
+	batch := slices.Clone(keys[max(j-n, 0):j])
+	slices.Reverse(batch)
+	return batch
+}
+
+// last returns the key of a flow's latest job, if it has any.
+func (x *jobIndex) last(flowId FlowId) (jobKey, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	keys := x.byFlow[flowId]
+	if len(keys) == 0 {
+		return jobKey{}, false
+	}
+	return keys[len(keys)-1], true
+}
+
+// queryBatch is how many jobs QueryJobs looks at a time.
+const queryBatch = 64
+
+// QueryJobs lists the jobs matching a query, newest first unless the query
+// asks otherwise. If more jobs match than the query's limit, it also returns
+// a cursor to fetch the next page with.
+func (s *JobEngine) QueryJobs(query JobQuery) ([]JobEntry, string, error) {
+	// the scan starts just past from, which an id of "" puts before every
+	// job queued at the same time
+	var from *jobKey
+	switch {
+	case query.Cursor != "":
+		key, err := parseCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		from = &key
+	case query.Ascending && !query.Since.IsZero():
+		from = &jobKey{time: query.Since}
+	case !query.Ascending && !query.Until.IsZero():
+		from = &jobKey{time: query.Until}
+	}
+
+	// past reports whether a key is beyond the end of the query's range
+	past := func(key jobKey) bool {
+		if query.Ascending {
+			return !query.Until.IsZero() && !key.time.Before(query.Until)
+		}
+		return !query.Since.IsZero() && key.time.Before(query.Since)
+	}
+
+	entries := make([]JobEntry, 0)
+	full := func() bool {
+		return query.Limit > 0 && len(entries) > query.Limit
+	}
+	for !full() {
+		batch := s.index.next(query.FlowId, from, query.Ascending, queryBatch)
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, key := range batch {
+			if past(key) {
+				break
+			}
+			if info, ok := s.Jobs.Read(key.id); ok && query.matches(key, info) {
+				entries = append(entries, JobEntry{key.id, info})
+				if full() {
+					break
+				}
+			}
+		}
+		if past(batch[len(batch)-1]) {
+			break
+		}
+		from = &batch[len(batch)-1]
+	}
+
+	if !full() {
+		return entries, "", nil
+	}
+
+	entries = entries[:query.Limit]
+	last := entries[len(entries)-1]
+	return entries, keyOf(last.Id, last.Info).cursor(), nil
+}
+
+// LastJob returns the latest job of a flow, if it has run.
+func (s *JobEngine) LastJob(flowId FlowId) (JobEntry, bool) {
+	key, ok := s.index.last(flowId)
+	if !ok {
+		return JobEntry{}, false
+	}
+
+	info, ok := s.Jobs.Read(key.id)
+	return JobEntry{key.id, info}, ok
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"slices"
+	"time"
+)
+
+type Backoff int
+
+const (
+	BackoffFixed Backoff = iota
+	BackoffExponential
+)
+
+// RetryPolicy controls whether and when a failed step or flow is tried again.
+type RetryPolicy struct {
+	// MaxAttempts is the most times to try, including the first.
+	MaxAttempts int
+	Backoff     Backoff
+	// Delay is how long to wait before the first retry.
+	Delay time.Duration
+	// MaxDelay caps the wait between exponentially backed off retries, or 0
+	// for no cap.
+	MaxDelay time.Duration
+	// ExitCodes restricts retries to failures with one of these exit codes.
+	// Any failure is retried if it is empty.
+	ExitCodes []int
+}
+
+// allows reports whether another attempt may be made after the given number
+// of attempts, the last of which exited with exitCode.
+func (p *RetryPolicy) allows(attempts int, exitCode int) bool {
+	if p == nil || attempts >= p.MaxAttempts {
+		return false
+	}
+	return len(p.ExitCodes) == 0 || slices.Contains(p.ExitCodes, exitCode)
+}
+
+// delay returns how long to wait before the given retry, counting from 1.
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	if p.Backoff != BackoffExponential {
+		return p.Delay
+	}
+
+	delay := p.Delay
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if (p.MaxDelay > 0 && delay >= p.MaxDelay) || delay <= 0 {
+			break
+		}
+	}
+
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		return p.MaxDelay
+	}
+	return delay
+}
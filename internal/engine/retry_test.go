@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cases := []struct {
+		policy   RetryPolicy
+		retry    int
+		expected time.Duration
+	}{
+		{RetryPolicy{Delay: time.Second}, 1, time.Second},
+		{RetryPolicy{Delay: time.Second}, 5, time.Second},
+		{RetryPolicy{Backoff: BackoffExponential, Delay: time.Second}, 1, time.Second},
+		{RetryPolicy{Backoff: BackoffExponential, Delay: time.Second}, 4, 8 * time.Second},
+		{RetryPolicy{Backoff: BackoffExponential, Delay: time.Second, MaxDelay: 5 * time.Second}, 4, 5 * time.Second},
+		{RetryPolicy{Backoff: BackoffExponential, Delay: time.Hour, MaxDelay: 24 * time.Hour}, 1000, 24 * time.Hour},
+	}
+
+	for i, tc := range cases {
+		if result := tc.policy.delay(tc.retry); result != tc.expected {
+			t.Fatalf("[%d] got: %v, expected: %v", i, result, tc.expected)
+		}
+	}
+}
+
+func TestRetryAllows(t *testing.T) {
+	var none *RetryPolicy
+	if none.allows(1, 1) {
+		t.Fatalf("got: retry without a policy, expected none")
+	}
+
+	policy := &RetryPolicy{MaxAttempts: 3, ExitCodes: []int{75}}
+	cases := []struct {
+		attempts int
+		exitCode int
+		expected bool
+	}{
+		{1, 75, true},
+		{2, 75, true},
+		{3, 75, false},
+		{1, 1, false},
+	}
+
+	for i, tc := range cases {
+		if result := policy.allows(tc.attempts, tc.exitCode); result != tc.expected {
+			t.Fatalf("[%d] got: %v, expected: %v", i, result, tc.expected)
+		}
+	}
+}
@@ -1,53 +1,554 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-func runJob(job *Job, report func(func(info *JobInfo))) bool {
+// killGracePeriod is how long a cancelled or timed out step has to exit after
+// being asked to terminate before it is killed.
+var killGracePeriod = 10 * time.Second
+
+// teardownTimeout is how long the steps that start after a job is cancelled
+// or times out, such as those with if: always(), have between them to run.
+var teardownTimeout = time.Minute
+
+// withTimeout is context.WithTimeout, except that a timeout of 0 means none.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// maxStepOutput caps how much of a step's standard output is kept for later
+// steps to refer to.
+const maxStepOutput = 1 << 20
+
+// stepResult is how an attempt at a step ended.
+type stepResult struct {
+	state    JobState
+	exitCode int
+	signal   string
+	message  string
+	// retryable is set for failures a retry policy may try again.
+	retryable bool
+}
+
+// jobRun holds what is needed to run the steps of a single job.
+type jobRun struct {
+	job *Job
+	// ctx is cancelled when the job is cancelled.
+	ctx context.Context
+	// flowCtx is done when ctx is, or when the flow times out.
+	flowCtx  context.Context
+	report   func(func(info *JobInfo))
+	progress func(func(info *JobInfo))
+	// attempts counts how many times each step has been tried.
+	attempts []int
+	// vars are the variables steps can refer to, including the output of
+	// each step that has finished so far. Steps run in parallel, so it is
+	// guarded by varsMu.
+	vars   map[string]string
+	varsMu sync.Mutex
+	// secrets are the values of each step's secrets.
+	secrets []map[string]string
+	masker  masker
+
+	// teardownCtx bounds the steps that start once flowCtx is done.
+	teardownCtx context.Context
+}
+
+// runJob runs the steps of a job, each once the steps it needs have finished
+// and if its condition holds, retrying steps and the whole flow as their
+// retry policies allow. Changes to the job are passed to report,
+// except for step output, which is passed to progress a line at a time as it
+// is written.
+func runJob(ctx context.Context, job *Job, report func(func(info *JobInfo)), progress func(func(info *JobInfo))) bool {
+	if ctx.Err() != nil {
+		report(func(info *JobInfo) {
+			info.State = JobCancelled
+			info.FinishedAt = time.Now()
+		})
+		return false
+	}
+
 	report(func(info *JobInfo) {
 		info.State = JobRunning
+		info.StartedAt = time.Now()
 	})
 
-	for i, step := range job.Steps {
-		var output []byte = nil
-		var state JobState = JobRunning
+	flowCtx, cancelFlow := withTimeout(ctx, job.Timeout)
+	defer cancelFlow()
 
-		input := strings.Join(step.Args, " ")
+	secrets, values, err := lookupSecrets(job.Steps, job.secrets)
+	if err != nil {
+		report(func(info *JobInfo) {
+			if len(info.Steps) > 0 {
+				info.Steps[0].Lines = append(info.Steps[0].Lines, systemLine(0, fmt.Sprintf("Job failed to start:\n  %s", err)))
+			}
+			info.State = JobFailed
+			info.FinishedAt = time.Now()
+		})
+		return false
+	}
+
+	// steps that run after a step they need fails or is skipped see its
+	// output as empty
+	vars := templateVars(job)
+	for i := range job.Steps {
+		setStepVars(vars, job.Steps, i, "", nil)
+	}
+
+	r := &jobRun{
+		job:      job,
+		ctx:      ctx,
+		flowCtx:  flowCtx,
+		report:   report,
+		progress: progress,
+		attempts: make([]int, len(job.Steps)),
+		vars:     vars,
+		secrets:  secrets,
+		masker:   newMasker(values),
+	}
+
+	for attempt := 1; ; attempt++ {
+		failed, result := r.runSteps()
+		if result.state == JobSucceeded {
+			break
+		}
 
-		output, err := runStep(&step)
-		if err != nil {
-			state = JobFailed
-			output = []byte(fmt.Sprintf("Step failed with error:\n  %s\n\n%s", err.Error(), output))
+		if result.retryable && job.Retry.allows(attempt, result.exitCode) {
+			delay := job.Retry.delay(attempt)
+			r.log(failed, fmt.Sprintf("Retrying flow in %s (attempt %d of %d)", delay, attempt+1, job.Retry.MaxAttempts))
+			if r.wait(r.flowCtx, delay) {
+				continue
+			}
+			result, _ = r.stopped()
+			r.log(failed, result.message)
 		}
 
 		report(func(info *JobInfo) {
-			info.CurrentStep = i
-			info.Steps[i].Input = input
-			info.Steps[i].Output = output
-			info.State = state
+			info.State = result.state
+			info.FinishedAt = time.Now()
 		})
-
-		if state == JobFailed {
-			return false
-		}
+		return false
 	}
 
 	report(func(info *JobInfo) {
 		info.State = JobSucceeded
+		info.FinishedAt = time.Now()
 	})
 
 	return true
 }
 
-func runStep(step *Step) ([]byte, error) {
+// stepDone is how a step run by runSteps ended.
+type stepDone struct {
+	index  int
+	result stepResult
+}
+
+// runSteps runs every step of the job once. Once the steps a step needs
+// have finished it is started if its condition holds, or skipped if not.
+// After the job is cancelled or times out only steps whose condition holds
+// despite it, such as always() and failure(), start, with teardownTimeout to
+// run, and the rest are marked skipped. It returns the index of the first step
+// that failed without being allowed to, and how it ended.
+func (r *jobRun) runSteps() (int, stepResult) {
+	steps := r.job.Steps
+	states := make([]JobState, len(steps))
+	r.report(func(info *JobInfo) {
+		for i := range info.Steps {
+			info.Steps[i].State = JobPending
+		}
+	})
+
+	// succeeded reports whether a finished step counts as having succeeded
+	succeeded := func(i int) bool {
+		return states[i] == JobSucceeded || states[i].Finished() && states[i] != JobSkipped && steps[i].ContinueOnError
+	}
+
+	done := make(chan stepDone)
+	running := 0
+	failed := -1
+	var failure stepResult
+	for {
+		for decided := true; decided; {
+			decided = false
+
+			// once the job stops, the steps left see it as having failed
+			teardown := r.flowCtx.Err() != nil
+			if teardown && failed < 0 {
+				failed = max(slices.Index(states, JobPending), 0)
+				failure, _ = r.stopped()
+			}
+
+			for i := range steps {
+				if states[i] != JobPending {
+					continue
+				}
+
+				needs := stepNeeds(steps, i)
+				if slices.ContainsFunc(needs, func(need int) bool { return !states[need].Finished() }) {
+					continue
+				}
+
+				decided = true
+				run, err := r.shouldRun(i, failed >= 0, !slices.ContainsFunc(needs, func(need int) bool { return !succeeded(need) }))
+				if err != nil {
+					r.log(i, fmt.Sprintf("Step failed to start:\n  %s", err))
+					states[i] = JobFailed
+					r.report(func(info *JobInfo) {
+						info.Steps[i].State = JobFailed
+					})
+					if failed < 0 && !steps[i].ContinueOnError {
+						failed, failure = i, stepResult{state: JobFailed, exitCode: -1}
+					}
+					continue
+				}
+				if !run {
+					states[i] = JobSkipped
+					r.report(func(info *JobInfo) {
+						info.Steps[i].State = JobSkipped
+					})
+					continue
+				}
+
+				if teardown && r.teardownCtx == nil {
+					var cancel context.CancelFunc
+					r.teardownCtx, cancel = context.WithTimeout(context.Background(), teardownTimeout)
+					defer cancel()
+				}
+
+				states[i] = JobRunning
+				running++
+				go func() {
+					done <- stepDone{i, r.tryStep(i, teardown)}
+				}()
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		finished := <-done
+		running--
+		states[finished.index] = finished.result.state
+		r.report(func(info *JobInfo) {
+			info.Steps[finished.index].State = finished.result.state
+		})
+
+		if finished.result.state == JobSucceeded {
+			continue
+		}
+		if steps[finished.index].ContinueOnError {
+			r.log(finished.index, "Continuing, as the step may fail")
+		} else if failed < 0 {
+			failed, failure = finished.index, finished.result
+		}
+	}
+
+	r.report(func(info *JobInfo) {
+		for i, state := range states {
+			if state == JobPending {
+				info.Steps[i].State = JobSkipped
+			}
+		}
+	})
+
+	if failed >= 0 {
+		return failed, failure
+	}
+	if stopped, ok := r.stopped(); ok {
+		return max(slices.Index(states, JobPending), 0), stopped
+	}
+	return len(steps) - 1, stepResult{state: JobSucceeded}
+}
+
+// shouldRun evaluates a step's condition, given whether a step has failed and
+// whether every step it needs succeeded.
+func (r *jobRun) shouldRun(i int, failed bool, needsSucceeded bool) (bool, error) {
+	step := r.job.Steps[i]
+	cond, err := parseCondition(step.If)
+	if err != nil {
+		return false, err
+	}
+
+	r.varsMu.Lock()
+	defer r.varsMu.Unlock()
+
+	// the step's environment may refer to variables too
+	if expanded, err := expandStep(step, r.vars); err == nil {
+		step = expanded
+	}
+	return cond.holds(conditionEnv{
+		lookup: func(name string) string {
+			if key, ok := strings.CutPrefix(name, "env."); ok {
+				return step.Env[key]
+			}
+			return r.vars[name]
+		},
+		failed:         failed,
+		needsSucceeded: needsSucceeded,
+	}), nil
+}
+
+// tryStep runs a step, retrying it as its retry policy allows. Teardown steps
+// are those started after the job stopped.
+func (r *jobRun) tryStep(i int, teardown bool) stepResult {
+	r.varsMu.Lock()
+	step, err := expandStep(r.job.Steps[i], r.vars)
+	r.varsMu.Unlock()
+	if err != nil {
+		step = r.job.Steps[i]
+	}
+
+	input := r.masker.mask(strings.Join(step.Args, " "))
+	r.report(func(info *JobInfo) {
+		info.CurrentStep = i
+		info.Steps[i].State = JobRunning
+		info.Steps[i].Input = input
+		if info.Steps[i].StartedAt.IsZero() {
+			info.Steps[i].StartedAt = time.Now()
+		}
+	})
+
+	if err != nil {
+		result := stepResult{state: JobFailed, exitCode: -1, message: fmt.Sprintf("Step failed to start:\n  %s", err)}
+		r.log(i, r.masker.mask(result.message))
+		return result
+	}
+
+	for attempt := 1; ; attempt++ {
+		result := r.runAttempt(i, &step, teardown)
+		if result.state == JobSucceeded || !result.retryable || !step.Retry.allows(attempt, result.exitCode) {
+			return result
+		}
+
+		delay := step.Retry.delay(attempt)
+		r.log(i, fmt.Sprintf("Retrying step in %s (attempt %d of %d)", delay, attempt+1, step.Retry.MaxAttempts))
+		if !r.wait(r.stepCtx(teardown), delay) {
+			result, _ = r.ended(teardown)
+			r.log(i, result.message)
+			return result
+		}
+	}
+}
+
+// runAttempt makes a single attempt at running a step, recording its output
+// and how it ended.
+func (r *jobRun) runAttempt(i int, step *Step, teardown bool) stepResult {
+	attempt := r.attempts[i]
+	r.attempts[i]++
+
+	var output strings.Builder
+	outputs := make(map[string]string, len(step.Outputs))
+	emit := func(line OutputLine) {
+		if name, value, ok := parseSetOutput(line.Text); ok && line.Stream == Stdout {
+			if slices.Contains(step.Outputs, name) {
+				outputs[name] = value
+				return
+			}
+			line = systemLine(attempt, fmt.Sprintf("Ignoring output %q, which the step does not declare", name))
+		} else if line.Stream == Stdout && output.Len() < maxStepOutput {
+			if output.Len() > 0 {
+				output.WriteByte('\n')
+			}
+			output.WriteString(line.Text)
+		}
+
+		line.Attempt = attempt
+		line.Text = r.masker.mask(line.Text)
+		r.progress(func(info *JobInfo) {
+			info.Steps[i].Lines = append(info.Steps[i].Lines, line)
+		})
+	}
+	stdout := &lineWriter{stream: Stdout, emit: emit}
+	stderr := &lineWriter{stream: Stderr, emit: emit}
+
+	startedAt := time.Now()
+	stepCtx, cancelStep := withTimeout(r.stepCtx(teardown), step.Timeout)
+	err := runStep(stepCtx, step, stepEnv(*step, r.secrets[i]), stdout, stderr)
+	cancelStep()
+	finishedAt := time.Now()
+	stdout.Flush()
+	stderr.Flush()
+
+	result := stepResult{state: JobSucceeded, exitCode: exitCode(err), signal: exitSignal(err)}
+	if ended, ok := r.ended(teardown); ok {
+		result = ended
+	} else if errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		result.state = JobTimedOut
+		result.message = fmt.Sprintf("Step timed out after %s", step.Timeout)
+		result.retryable = true
+	} else if err != nil {
+		result.state = JobFailed
+		result.message = fmt.Sprintf("Step failed with error:\n  %s", err.Error())
+		result.retryable = true
+	}
+
+	masked := make(map[string]string, len(outputs))
+	for name, value := range outputs {
+		masked[name] = r.masker.mask(value)
+	}
+
+	r.varsMu.Lock()
+	setStepVars(r.vars, r.job.Steps, i, output.String(), outputs)
+	r.varsMu.Unlock()
+
+	r.report(func(info *JobInfo) {
+		if result.message != "" {
+			info.Steps[i].Lines = append(info.Steps[i].Lines, systemLine(attempt, r.masker.mask(result.message)))
+		}
+		info.Steps[i].Attempts = append(info.Steps[i].Attempts, StepAttempt{
+			State:      result.state,
+			ExitCode:   result.exitCode,
+			Signal:     result.signal,
+			StartedAt:  startedAt,
+			FinishedAt: finishedAt,
+		})
+		info.Steps[i].FinishedAt = finishedAt
+		if len(step.Outputs) > 0 {
+			info.Steps[i].Outputs = masked
+		}
+	})
+
+	return result
+}
+
+// stopped reports whether the job has been cancelled or timed out, and so
+// cannot go on.
+func (r *jobRun) stopped() (stepResult, bool) {
+	if r.ctx.Err() != nil {
+		return stepResult{state: JobCancelled, exitCode: -1, message: "Step cancelled"}, true
+	}
+	if r.flowCtx.Err() != nil {
+		return stepResult{state: JobTimedOut, exitCode: -1, message: fmt.Sprintf("Flow timed out after %s", r.job.Timeout)}, true
+	}
+	return stepResult{}, false
+}
+
+// stepCtx returns the context a step runs in, which for teardown steps
+// outlasts the job stopping.
+func (r *jobRun) stepCtx(teardown bool) context.Context {
+	if teardown {
+		return r.teardownCtx
+	}
+	return r.flowCtx
+}
+
+// ended reports whether a step can't go on, as the job stopped or, for a
+// teardown step, as it ran out of time.
+func (r *jobRun) ended(teardown bool) (stepResult, bool) {
+	if !teardown {
+		return r.stopped()
+	}
+	if r.teardownCtx.Err() != nil {
+		return stepResult{state: JobTimedOut, exitCode: -1, message: fmt.Sprintf("Step timed out after %s, as the job had stopped", teardownTimeout)}, true
+	}
+	return stepResult{}, false
+}
+
+// wait waits before a retry, returning false if ctx is done meanwhile.
+func (r *jobRun) wait(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// log adds a message from soko to the latest attempt at a step.
+func (r *jobRun) log(i int, message string) {
+	attempt := max(r.attempts[i]-1, 0)
+	r.report(func(info *JobInfo) {
+		info.Steps[i].Lines = append(info.Steps[i].Lines, systemLine(attempt, message))
+	})
+}
+
+func systemLine(attempt int, text string) OutputLine {
+	return OutputLine{
+		Time:    time.Now(),
+		Stream:  System,
+		Text:    text,
+		Attempt: attempt,
+	}
+}
+
+// exitCode returns the exit code of a finished step, or -1 if it did not
+// exit normally.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// exitSignal returns the name of the signal that killed a finished step, or
+// an empty string if it was not killed by one.
+func exitSignal(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return processSignal(exitErr.ProcessState)
+	}
+	return ""
+}
+
+// runStep runs a step's command in its own process group with the given
+// environment, writing its output streams as they are produced. If ctx is
+// cancelled or times out the group is asked to terminate, and killed if it is
+// still running after killGracePeriod.
+func runStep(ctx context.Context, step *Step, env []string, stdout io.Writer, stderr io.Writer) error {
 	if len(step.Args) == 0 {
-		return nil, errors.New("Step is empty")
+		return errors.New("Step is empty")
 	}
 
 	cmd := exec.Command(step.Args[0], step.Args[1:]...)
-	return cmd.CombinedOutput()
+	cmd.Dir = step.Dir
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+
+		terminate(cmd)
+		select {
+		case <-exited:
+		case <-time.After(killGracePeriod):
+			kill(cmd)
+		}
+	}()
+
+	err := cmd.Wait()
+	close(exited)
+	return err
 }
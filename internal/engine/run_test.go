@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStepKillsAfterGracePeriod(t *testing.T) {
+	killGracePeriod = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err := runStep(ctx, &Step{Args: []string{"sh", "-c", "trap '' TERM; sleep 30"}}, nil, io.Discard, io.Discard)
+	if err == nil {
+		t.Fatalf("got: no error, expected the step to be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("got: step ran for %v, expected it to be killed", elapsed)
+	}
+}
+
+func TestRunStepWorkdir(t *testing.T) {
+	dir := t.TempDir()
+
+	var stdout strings.Builder
+	err := runStep(context.Background(), &Step{Args: []string{"pwd"}, Dir: dir}, nil, &stdout, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := filepath.EvalSymlinks(dir)
+	if got, _ := filepath.EvalSymlinks(strings.TrimSpace(stdout.String())); got != expected {
+		t.Fatalf("got: %s, expected: %s", got, expected)
+	}
+}
@@ -7,54 +7,201 @@ import (
 	"time"
 )
 
+// FlowSchedule is a cron-style schedule. A nil field matches every value.
+// Fields are compared against the wall clock in Location, or the local time
+// zone if Location is nil.
 type FlowSchedule struct {
-	Minutes []int
-	Hours   []int
-	Days    []time.Weekday
+	Minutes     []int
+	Hours       []int
+	DaysOfMonth []int
+	Months      []time.Month
+	Days        []time.Weekday
+	Location    *time.Location
 }
 
-func (s FlowSchedule) Matches(time time.Time) bool {
-	return (s.Days == nil || slices.Contains(s.Days, time.Weekday())) &&
+// wallClock returns the date and time shown on a clock at t, truncated to the
+// minute, as a UTC time so it can be compared and stepped without zone
+// transitions getting in the way.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// repeated reports whether the wall clock time at t was already shown
+// earlier, as happens for an hour when clocks go back.
+func repeated(t time.Time) bool {
+	_, offset := t.Zone()
+	_, dayAgoOffset := t.Add(-24 * time.Hour).Zone()
+	if dayAgoOffset <= offset {
+		return false
+	}
+
+	earlier := t.Add(-time.Duration(dayAgoOffset-offset) * time.Second)
+	return wallClock(earlier).Equal(wallClock(t))
+}
+
+// Matches reports whether the flow should run in the minute containing t.
+// It expects to be called once for every minute. Wall clock times that are
+// shown twice when clocks go back only match the first time, and times that
+// are skipped when clocks go forward match in the first minute after the
+// jump.
+func (s FlowSchedule) Matches(t time.Time) bool {
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+
+	if repeated(t) {
+		return false
+	}
+
+	wall := wallClock(t)
+	if s.matchesWall(wall) {
+		return true
+	}
+
+	for skipped := wallClock(t.Add(-time.Minute)).Add(time.Minute); skipped.Before(wall); skipped = skipped.Add(time.Minute) {
+		if s.matchesWall(skipped) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxNextSearch bounds how far ahead Next looks, long enough to find a leap
+// day that also has to fall on a given weekday.
+const maxNextSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the start of the first minute after t that the schedule
+// matches, or the zero time if it never does.
+func (s FlowSchedule) Next(t time.Time) time.Time {
+	location := s.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	end := t.Add(maxNextSearch)
+	for next := t.Truncate(time.Minute).Add(time.Minute); next.Before(end); {
+		wall := next.In(location)
+		if !(s.Months == nil || slices.Contains(s.Months, wall.Month())) || !s.dayMatches(wall) {
+			// nothing today, so skip to tomorrow
+			next = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+
+		if s.Matches(next) {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
+
+func (s FlowSchedule) matchesWall(time time.Time) bool {
+	return (s.Months == nil || slices.Contains(s.Months, time.Month())) &&
+		s.dayMatches(time) &&
 		(s.Hours == nil || slices.Contains(s.Hours, time.Hour())) &&
 		(s.Minutes == nil || slices.Contains(s.Minutes, time.Minute()))
 }
 
-func (s FlowSchedule) String() string {
-	days := ""
-	minutes := "every minute"
+// dayMatches follows cron: when both the day of the month and the day of the
+// week are restricted, a time matches if either of them does.
+func (s FlowSchedule) dayMatches(time time.Time) bool {
+	dayOfMonth := s.DaysOfMonth == nil || slices.Contains(s.DaysOfMonth, time.Day())
+	weekday := s.Days == nil || slices.Contains(s.Days, time.Weekday())
 
+	if s.DaysOfMonth != nil && s.Days != nil {
+		return dayOfMonth || weekday
+	}
+	return dayOfMonth && weekday
+}
+
+func joinInts(values []int, format string) string {
+	combined := make([]string, len(values))
+	for i, value := range values {
+		combined[i] = fmt.Sprintf(format, value)
+	}
+	return strings.Join(combined, ", ")
+}
+
+// maxListedTimes bounds how many hour:minute pairs String spells out before
+// describing minutes and hours separately.
+const maxListedTimes = 12
+
+func (s FlowSchedule) timeString() string {
+	switch {
+	case s.Hours == nil && s.Minutes == nil:
+		return "every minute"
+	case s.Hours == nil:
+		return "every hour at minute " + joinInts(s.Minutes, "%02d")
+	case s.Minutes == nil:
+		combined := make([]string, len(s.Hours))
+		for i, hour := range s.Hours {
+			combined[i] = fmt.Sprintf("every minute from %d:00 to %d:59", hour, hour)
+		}
+		return strings.Join(combined, ", ")
+	case len(s.Hours)*len(s.Minutes) > maxListedTimes:
+		return fmt.Sprintf("at minute %s past hour %s", joinInts(s.Minutes, "%02d"), joinInts(s.Hours, "%d"))
+	default:
+		combined := make([]string, 0, len(s.Hours)*len(s.Minutes))
+		for _, hour := range s.Hours {
+			for _, minute := range s.Minutes {
+				combined = append(combined, fmt.Sprintf("%d:%02d", hour, minute))
+			}
+		}
+		return "at " + strings.Join(combined, ", ")
+	}
+}
+
+func (s FlowSchedule) dayString() string {
+	daysOfMonth := ""
+	if s.DaysOfMonth != nil {
+		daysOfMonth = "on day " + joinInts(s.DaysOfMonth, "%d") + " of the month"
+	}
+
+	days := ""
 	if s.Days != nil {
 		combined := make([]string, len(s.Days))
 		for i, day := range s.Days {
 			combined[i] = day.String()
 		}
-
 		days = "on " + strings.Join(combined, ", ")
 	}
 
-	if s.Hours != nil {
-		minutesCount := 1
-		if s.Minutes != nil {
-			minutesCount = len(s.Minutes)
-		}
+	switch {
+	case daysOfMonth != "" && days != "":
+		return daysOfMonth + " or " + days
+	case daysOfMonth != "":
+		return daysOfMonth
+	default:
+		return days
+	}
+}
 
-		combined := make([]string, len(s.Hours)*minutesCount)
-		for i, hour := range s.Hours {
-			if s.Minutes == nil {
-				combined[i] = fmt.Sprintf("every minute from %d:00 to %d:59", hour, hour)
-			}
+func (s FlowSchedule) monthString() string {
+	if s.Months == nil {
+		return ""
+	}
 
-			for j, minute := range s.Minutes {
-				combined[i*minutesCount+j] = fmt.Sprintf("%d:%d", hour, minute)
-			}
-		}
+	combined := make([]string, len(s.Months))
+	for i, month := range s.Months {
+		combined[i] = month.String()
+	}
+	return "in " + strings.Join(combined, ", ")
+}
 
-		minutes = strings.Join(combined, ", ")
+func (s FlowSchedule) String() string {
+	parts := []string{s.timeString()}
+
+	for _, part := range []string{s.dayString(), s.monthString()} {
+		if part != "" {
+			parts = append(parts, part)
+		}
 	}
 
-	if days == "" {
-		return minutes
-	} else {
-		return fmt.Sprintf("%s %s", minutes, days)
+	if s.Location != nil {
+		parts = append(parts, "("+s.Location.String()+")")
 	}
+
+	return strings.Join(parts, " ")
 }
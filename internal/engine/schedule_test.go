@@ -83,6 +83,50 @@ func TestScheduleMatches(t *testing.T) {
 			date,
 			false,
 		},
+		{
+			engine.FlowSchedule{
+				Months: []time.Month{date.Month()},
+			},
+			date,
+			true,
+		},
+		{
+			engine.FlowSchedule{
+				Months: []time.Month{date.Month() + 1},
+			},
+			date,
+			false,
+		},
+		{
+			engine.FlowSchedule{
+				DaysOfMonth: []int{date.Day()},
+			},
+			date,
+			true,
+		},
+		{
+			engine.FlowSchedule{
+				DaysOfMonth: []int{date.Day() + 1},
+			},
+			date,
+			false,
+		},
+		{
+			engine.FlowSchedule{
+				DaysOfMonth: []int{date.Day() + 1},
+				Days:        []time.Weekday{date.Weekday()},
+			},
+			date,
+			true,
+		},
+		{
+			engine.FlowSchedule{
+				DaysOfMonth: []int{date.Day() + 1},
+				Days:        []time.Weekday{date.Weekday() + 1},
+			},
+			date,
+			false,
+		},
 	}
 
 	for i, tc := range cases {
@@ -95,3 +139,125 @@ func TestScheduleMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestScheduleString(t *testing.T) {
+	cases := []struct {
+		schedule engine.FlowSchedule
+		expected string
+	}{
+		{engine.FlowSchedule{}, "every minute"},
+		{engine.FlowSchedule{Minutes: []int{0, 30}}, "every hour at minute 00, 30"},
+		{engine.FlowSchedule{Hours: []int{9}}, "every minute from 9:00 to 9:59"},
+		{engine.FlowSchedule{Minutes: []int{5}, Hours: []int{9, 17}}, "at 9:05, 17:05"},
+		{
+			engine.FlowSchedule{Minutes: []int{0, 15, 30, 45}, Hours: []int{9, 10, 11, 12}},
+			"at minute 00, 15, 30, 45 past hour 9, 10, 11, 12",
+		},
+		{
+			engine.FlowSchedule{Minutes: []int{0}, Hours: []int{0}, Days: []time.Weekday{time.Monday}},
+			"at 0:00 on Monday",
+		},
+		{
+			engine.FlowSchedule{Minutes: []int{0}, Hours: []int{0}, DaysOfMonth: []int{1}, Months: []time.Month{time.January}},
+			"at 0:00 on day 1 of the month in January",
+		},
+		{
+			engine.FlowSchedule{Minutes: []int{0}, Hours: []int{0}, DaysOfMonth: []int{1, 15}, Days: []time.Weekday{time.Friday}},
+			"at 0:00 on day 1, 15 of the month or on Friday",
+		},
+	}
+
+	for i, tc := range cases {
+		result := tc.schedule.String()
+		if result != tc.expected {
+			t.Fatalf("[%d] got: %q, expected: %q", i, result, tc.expected)
+		}
+	}
+}
+
+func TestScheduleMatchesLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := engine.FlowSchedule{
+		Minutes:  []int{0},
+		Hours:    []int{9},
+		Location: newYork,
+	}
+
+	if !schedule.Matches(time.Date(2024, 1, 15, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got: no match at 14:00 UTC in winter, expected a match")
+	}
+	if !schedule.Matches(time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got: no match at 13:00 UTC in summer, expected a match")
+	}
+	if schedule.Matches(time.Date(2024, 7, 15, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got: match at 9:00 UTC, expected none")
+	}
+}
+
+func TestScheduleMatchesDaylightSaving(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		day  time.Time
+	}{
+		{"clocks go forward", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"clocks go back", time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule := engine.FlowSchedule{
+				Minutes:  []int{30},
+				Hours:    []int{1},
+				Location: london,
+			}
+
+			runs := 0
+			for minute := tc.day; minute.Before(tc.day.Add(24 * time.Hour)); minute = minute.Add(time.Minute) {
+				if schedule.Matches(minute) {
+					runs++
+				}
+			}
+
+			if runs != 1 {
+				t.Fatalf("got: %d runs, expected: 1", runs)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2024, 3, 30, 12, 0, 30, 0, time.UTC)
+	cases := []struct {
+		name     string
+		schedule engine.FlowSchedule
+		expected time.Time
+	}{
+		{"every minute", engine.FlowSchedule{Location: time.UTC}, time.Date(2024, 3, 30, 12, 1, 0, 0, time.UTC)},
+		{"later today", engine.FlowSchedule{Minutes: []int{15}, Hours: []int{18}, Location: time.UTC}, time.Date(2024, 3, 30, 18, 15, 0, 0, time.UTC)},
+		{"next month", engine.FlowSchedule{Minutes: []int{0}, Hours: []int{0}, DaysOfMonth: []int{1}, Location: time.UTC}, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", engine.FlowSchedule{Minutes: []int{0}, Hours: []int{0}, DaysOfMonth: []int{29}, Months: []time.Month{time.February}, Location: time.UTC}, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// 1:30 is skipped when the clocks go forward, so it runs at 2:00 BST
+		{"skipped by daylight saving", engine.FlowSchedule{Minutes: []int{30}, Hours: []int{1}, Location: london}, time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)},
+		{"never", engine.FlowSchedule{DaysOfMonth: []int{30}, Months: []time.Month{time.February}, Location: time.UTC}, time.Time{}},
+	}
+
+	for _, tc := range cases {
+		if next := tc.schedule.Next(from); !next.Equal(tc.expected) {
+			t.Fatalf("%s got: %v, expected: %v", tc.name, next, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"container/heap"
+	"log"
+	"reflect"
+	"time"
+)
+
+// maxScheduleSleep bounds how long the scheduler sleeps, so it notices the
+// clock jumping or the host resuming from suspend soon after it happens.
+const maxScheduleSleep = time.Minute
+
+// clockJumpTolerance is how far the wall clock may drift from the monotonic
+// clock while the scheduler sleeps before it reschedules every flow.
+const clockJumpTolerance = 2 * time.Second
+
+// scheduledRun is the next time a flow's schedule runs it.
+type scheduledRun struct {
+	at     time.Time
+	flowId FlowId
+}
+
+// runQueue is a heap of scheduled runs, earliest first.
+type runQueue []scheduledRun
+
+func (q runQueue) Len() int           { return len(q) }
+func (q runQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q runQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *runQueue) Push(x any)        { *q = append(*q, x.(scheduledRun)) }
+
+func (q *runQueue) Pop() any {
+	old := *q
+	run := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return run
+}
+
+// scheduler tracks when each scheduled flow last and next runs.
+type scheduler struct {
+	engine *JobEngine
+	flows  map[FlowId]Flow
+	// lastRuns is the latest time each flow's schedule was due, whether or
+	// not a job was started for it.
+	lastRuns map[FlowId]time.Time
+	queue    runQueue
+}
+
+// lastScheduled returns the latest time a job of a flow was scheduled for,
+// or the zero time if there is none.
+func (s *JobEngine) lastScheduled(flowId FlowId) time.Time {
+	var last time.Time
+	for _, info := range s.Jobs.Filter(func(_ JobId, info JobInfo) bool { return info.FlowId == flowId }) {
+		if info.ScheduledAt.After(last) {
+			last = info.ScheduledAt
+		}
+	}
+	return last
+}
+
+// reschedule works out when every flow next runs from scratch, starting jobs
+// for any runs that are due.
+func (s *scheduler) reschedule(now time.Time) {
+	for id := range s.lastRuns {
+		if flow, ok := s.flows[id]; !ok || flow.Schedule == nil {
+			delete(s.lastRuns, id)
+		}
+	}
+
+	s.queue = s.queue[:0]
+	for id, flow := range s.flows {
+		if flow.Schedule != nil {
+			s.run(id, flow, now)
+		}
+	}
+}
+
+// update replaces the flows being scheduled. Flows whose schedule changed
+// carry on from now, so runs the new schedule was never due for aren't
+// caught up on.
+func (s *scheduler) update(flows map[FlowId]Flow, now time.Time) {
+	for id, flow := range flows {
+		if old, ok := s.flows[id]; ok && !reflect.DeepEqual(old.Schedule, flow.Schedule) {
+			s.lastRuns[id] = now
+		}
+	}
+	s.flows = flows
+	s.reschedule(now)
+}
+
+// runDue starts jobs for the flows whose next run has come.
+func (s *scheduler) runDue(now time.Time) {
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		run := heap.Pop(&s.queue).(scheduledRun)
+		if flow, ok := s.flows[run.flowId]; ok && flow.Schedule != nil {
+			s.run(run.flowId, flow, now)
+		}
+	}
+}
+
+// run starts jobs for the times a flow's schedule has been due since it last
+// ran, as its catch-up policy allows, and queues its next run. Flows that
+// haven't run since the scheduler started carry on from their job history.
+func (s *scheduler) run(id FlowId, flow Flow, now time.Time) {
+	last, ok := s.lastRuns[id]
+	if !ok {
+		last = s.engine.lastScheduled(id)
+	}
+	if last.After(now) {
+		log.Printf("Clock went back from %s, resuming the schedule of %s from now", last.Format(time.RFC3339), id)
+		last = time.Time{}
+	}
+	if last.IsZero() {
+		// just before this minute, so it runs if it is due
+		last = now.Truncate(time.Minute).Add(-time.Nanosecond)
+	}
+
+	runs, latest, skipped := flow.Catchup.runs(*flow.Schedule, last, now)
+	if !latest.IsZero() {
+		last = latest
+	}
+	s.lastRuns[id] = last
+
+	if skipped > 0 {
+		log.Printf("Skipping %d missed runs of %s", skipped, id)
+	}
+	for _, run := range runs {
+		_, err := s.engine.StartJobWithOptions(id, JobOptions{ScheduledAt: run})
+		if err != nil {
+			log.Printf("Failed to start scheduled job of %s: %v", id, err)
+		}
+	}
+
+	if next := flow.Schedule.Next(last); !next.IsZero() {
+		heap.Push(&s.queue, scheduledRun{at: next, flowId: id})
+	}
+}
+
+// ProcessSchedule starts jobs of flows as their schedules come due, sleeping
+// until the next one does. Flows are rescheduled when they change, and when
+// the clock jumps or the host resumes from suspend, with runs missed
+// meanwhile handled by each flow's catch-up policy.
+func (s *JobEngine) ProcessSchedule(quit chan bool) {
+	changes := s.Flows.Watch()
+	sched := scheduler{
+		engine:   s,
+		flows:    s.Flows.Snapshot(),
+		lastRuns: make(map[FlowId]time.Time),
+	}
+	// times are compared on the wall clock, so clock jumps are noticed
+	sched.reschedule(time.Now().Round(0))
+
+	timer := time.NewTimer(maxScheduleSleep)
+	defer timer.Stop()
+
+	for {
+		sleep := maxScheduleSleep
+		if len(sched.queue) > 0 {
+			sleep = min(sleep, max(time.Until(sched.queue[0].at), 0))
+		}
+		timer.Reset(sleep)
+		slept := time.Now()
+
+		select {
+		case <-quit:
+			return
+		case <-changes:
+			sched.update(s.Flows.Snapshot(), time.Now().Round(0))
+		case <-timer.C:
+			woke := time.Now()
+			drift := woke.Round(0).Sub(slept.Round(0)) - woke.Sub(slept)
+			if drift.Abs() > clockJumpTolerance {
+				log.Printf("Clock jumped by %s, rescheduling flows", drift.Round(time.Second))
+				sched.reschedule(woke.Round(0))
+			} else {
+				sched.runDue(woke.Round(0))
+			}
+		}
+	}
+}
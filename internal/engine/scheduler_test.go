@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fourls/soko/internal/crud"
+)
+
+func TestSchedulerResetsChangedSchedules(t *testing.T) {
+	jobEngine := &JobEngine{
+		Jobs:     crud.New[JobId, JobInfo](),
+		Flows:    crud.New[FlowId, Flow](),
+		cancels:  crud.New[JobId, context.CancelFunc](),
+		index:    newJobIndex(),
+		saving:   new(sync.Mutex),
+		jobQueue: make(chan *Job, 1024),
+	}
+	defer jobEngine.Jobs.Close()
+	defer jobEngine.Flows.Close()
+	defer jobEngine.cancels.Close()
+
+	daily := Flow{
+		Id:       "p.flow",
+		Steps:    []Step{{Args: []string{"true"}}},
+		Schedule: &FlowSchedule{Minutes: []int{0}, Hours: []int{0}, Location: time.UTC},
+		Catchup:  Catchup{Policy: CatchupAll},
+	}
+	hourly := daily
+	hourly.Schedule = &FlowSchedule{Minutes: []int{0}, Location: time.UTC}
+	jobEngine.Flows.Create(daily.Id, hourly)
+
+	midnight := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	sched := scheduler{
+		engine:   jobEngine,
+		flows:    map[FlowId]Flow{daily.Id: daily},
+		lastRuns: map[FlowId]time.Time{daily.Id: midnight},
+	}
+
+	now := midnight.Add(15*time.Hour + 30*time.Second)
+	sched.update(map[FlowId]Flow{daily.Id: hourly}, now)
+	if jobs := jobEngine.Jobs.Snapshot(); len(jobs) != 0 {
+		t.Fatalf("got: %d jobs, expected none for runs the new schedule missed", len(jobs))
+	}
+	if next := sched.queue[0].at; !next.Equal(midnight.Add(16 * time.Hour)) {
+		t.Fatalf("got: next run at %v, expected 16:00", next)
+	}
+
+	// changes that keep the schedule don't lose track of missed runs
+	later := now.Add(2 * time.Hour)
+	sched.update(map[FlowId]Flow{daily.Id: hourly}, later)
+	if jobs := jobEngine.Jobs.Snapshot(); len(jobs) != 2 {
+		t.Fatalf("got: %d jobs, expected the two missed runs", len(jobs))
+	}
+}
@@ -0,0 +1,9 @@
+package engine
+
+// JobStore persists job records so they survive the engine being restarted.
+type JobStore interface {
+	// Load returns every job record saved so far.
+	Load() (map[JobId]JobInfo, error)
+	// Save records the latest state of a job.
+	Save(id JobId, info JobInfo) error
+}
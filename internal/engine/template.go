@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
+
+// Steps can refer to these variables in their arguments and environment as
+// ${{ name }}, along with inputs.<name> for each input of the flow, and
+// steps.<step>.output and steps.<step>.outputs.<name> for the standard output
+// and declared outputs of each step it needs, directly or indirectly. Steps
+// are referred to by id, or by number counting from 1.
+const (
+	varJobId         = "job.id"
+	varScheduledTime = "job.scheduled_time"
+	varFlowId        = "flow.id"
+	varProjectName   = "project.name"
+)
+
+// replaceVars calls replace with the name of each ${{ name }} in text,
+// substituting what it returns.
+func replaceVars(text string, replace func(name string) (string, error)) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "${{")
+		if start < 0 {
+			b.WriteString(text)
+			return b.String(), nil
+		}
+
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed ${{ in %q", text)
+		}
+		end += start
+
+		value, err := replace(strings.TrimSpace(text[start+len("${{") : end]))
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(text[:start])
+		b.WriteString(value)
+		text = text[end+len("}}"):]
+	}
+}
+
+// expand replaces each ${{ name }} in text with the value of the variable
+// name.
+func expand(text string, vars map[string]string) (string, error) {
+	return replaceVars(text, func(name string) (string, error) {
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("unknown variable %q", name)
+		}
+		return value, nil
+	})
+}
+
+// templateVars returns the variables known when a job starts. Step outputs
+// are added as the steps finish.
+func templateVars(job *Job) map[string]string {
+	vars := map[string]string{
+		varJobId:         string(job.Id),
+		varScheduledTime: "",
+		varFlowId:        string(job.FlowId),
+		varProjectName:   job.Project,
+	}
+	if !job.ScheduledAt.IsZero() {
+		vars[varScheduledTime] = job.ScheduledAt.Format(time.RFC3339)
+	}
+	for name, value := range job.Inputs {
+		vars["inputs."+name] = value
+	}
+	return vars
+}
+
+// eachTemplate calls fn with each of a step's arguments and environment
+// values, replacing it with what fn returns.
+func eachTemplate(step Step, fn func(field string, text string) (string, error)) (Step, error) {
+	args := make([]string, len(step.Args))
+	for i, arg := range step.Args {
+		expanded, err := fn(fmt.Sprintf("argument %d", i+1), arg)
+		if err != nil {
+			return step, err
+		}
+		args[i] = expanded
+	}
+	step.Args = args
+
+	if step.Env != nil {
+		env := maps.Clone(step.Env)
+		for key, value := range env {
+			expanded, err := fn("env "+key, value)
+			if err != nil {
+				return step, err
+			}
+			env[key] = expanded
+		}
+		step.Env = env
+	}
+
+	return step, nil
+}
+
+// expandStep returns a copy of a step with variables in its arguments and
+// environment replaced by their values.
+func expandStep(step Step, vars map[string]string) (Step, error) {
+	return eachTemplate(step, func(field string, text string) (string, error) {
+		expanded, err := expand(text, vars)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		return expanded, nil
+	})
+}
+
+// Validate checks that the steps of the flow don't need each other in a
+// cycle, have valid conditions, and only refer to variables that will be set
+// when they run.
+func (f Flow) Validate() error {
+	if err := checkGraph(f.Steps); err != nil {
+		return err
+	}
+
+	base := map[string]string{
+		varJobId:         "",
+		varScheduledTime: "",
+		varFlowId:        "",
+		varProjectName:   "",
+	}
+	for _, input := range f.Inputs {
+		base["inputs."+input.Name] = ""
+	}
+
+	for i, step := range f.Steps {
+		known := maps.Clone(base)
+		for _, j := range ancestors(f.Steps, i) {
+			setStepVars(known, f.Steps, j, "", nil)
+		}
+
+		cond, err := parseCondition(step.If)
+		if err != nil {
+			return fmt.Errorf("%s: %w", stepName(f.Steps, i), err)
+		}
+		for _, name := range cond.vars {
+			if _, ok := known[name]; !ok && !strings.HasPrefix(name, "env.") {
+				return fmt.Errorf("%s: condition: unknown variable %q", stepName(f.Steps, i), name)
+			}
+		}
+
+		_, err = eachTemplate(step, func(field string, text string) (string, error) {
+			_, err := replaceVars(text, func(name string) (string, error) {
+				if _, ok := known[name]; !ok {
+					return "", fmt.Errorf("unknown variable %q", name)
+				}
+				return "", nil
+			})
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", field, err)
+			}
+			return text, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", stepName(f.Steps, i), err)
+		}
+	}
+
+	return nil
+}
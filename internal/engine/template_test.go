@@ -0,0 +1,87 @@
+package engine
+
+import "testing"
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"inputs.target": "staging", "inputs.n": "3"}
+
+	cases := []struct {
+		text     string
+		expected string
+		valid    bool
+	}{
+		{"plain", "plain", true},
+		{"${{ inputs.target }}", "staging", true},
+		{"deploy-${{inputs.target}}-${{ inputs.n }}.log", "deploy-staging-3.log", true},
+		{"$HOME ${ x } {{ y }}", "$HOME ${ x } {{ y }}", true},
+		{"${{ inputs.missing }}", "", false},
+		{"${{ inputs.target", "", false},
+	}
+
+	for _, tc := range cases {
+		result, err := expand(tc.text, vars)
+		if (err == nil) != tc.valid {
+			t.Fatalf("%q got error: %v, want valid: %v", tc.text, err, tc.valid)
+		}
+		if result != tc.expected {
+			t.Fatalf("%q got: %q, expected: %q", tc.text, result, tc.expected)
+		}
+	}
+}
+
+func TestFlowValidate(t *testing.T) {
+	cases := []struct {
+		steps []Step
+		valid bool
+	}{
+		{[]Step{{Args: []string{"echo", "${{ job.id }} ${{ flow.id }} ${{ project.name }} ${{ job.scheduled_time }}"}}}, true},
+		{[]Step{{Args: []string{"echo", "${{ inputs.target }}"}}}, true},
+		{[]Step{{Args: []string{"echo", "${{ inputs.other }}"}}}, false},
+		{[]Step{{Args: []string{"true"}}, {Args: []string{"echo"}, Env: map[string]string{"PREV": "${{ steps.1.output }}"}}}, true},
+		{[]Step{{Args: []string{"echo", "${{ steps.1.output }}"}}}, false},
+		{[]Step{{Args: []string{"echo", "${{ steps.2.output }}"}}, {Args: []string{"true"}}}, false},
+		{[]Step{{Args: []string{"echo", "${{ job.id"}}}, false},
+		{[]Step{{Args: []string{"true"}, Outputs: []string{"version"}}, {Args: []string{"echo", "${{ steps.1.outputs.version }}"}}}, true},
+		{[]Step{{Args: []string{"true"}}, {Args: []string{"echo", "${{ steps.1.outputs.version }}"}}}, false},
+		{[]Step{{Id: "a", Args: []string{"true"}}, {Args: []string{"true"}}, {Args: []string{"echo", "${{ steps.a.output }}"}}}, true},
+		{[]Step{{Id: "a", Args: []string{"true"}}, {Args: []string{"echo", "${{ steps.a.output }}"}, Needs: []int{}}}, false},
+		{[]Step{{Args: []string{"true"}, Needs: []int{1}}, {Args: []string{"true"}}}, false},
+		{[]Step{{Args: []string{"true"}, Needs: []int{2}}}, false},
+		{[]Step{{Args: []string{"true"}, If: "inputs.target == 'prod' && env.DEPLOY"}}, true},
+		{[]Step{{Args: []string{"true"}, If: "inputs.other"}}, false},
+		{[]Step{{Args: []string{"true"}, If: "failure() &&"}}, false},
+	}
+
+	for _, tc := range cases {
+		flow := Flow{
+			Inputs: []Input{{Name: "target"}},
+			Steps:  tc.steps,
+		}
+		if err := flow.Validate(); (err == nil) != tc.valid {
+			t.Fatalf("%v got error: %v, want valid: %v", tc.steps, err, tc.valid)
+		}
+	}
+}
+
+func TestParseSetOutput(t *testing.T) {
+	cases := []struct {
+		text  string
+		name  string
+		value string
+		ok    bool
+	}{
+		{"::set-output name=version::1.2.3", "version", "1.2.3", true},
+		{"::set-output name=empty::", "empty", "", true},
+		{"::set-output name=url::http://example.com", "url", "http://example.com", true},
+		{"::set-output name=multi::a%0Ab%25", "multi", "a\nb%", true},
+		{"::set-output name=broken", "", "", false},
+		{"echo ::set-output name=x::y", "", "", false},
+	}
+
+	for _, tc := range cases {
+		name, value, ok := parseSetOutput(tc.text)
+		if name != tc.name || value != tc.value || ok != tc.ok {
+			t.Fatalf("%q got: %q, %q, %v, expected: %q, %q, %v", tc.text, name, value, ok, tc.name, tc.value, tc.ok)
+		}
+	}
+}
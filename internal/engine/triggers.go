@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"log"
+	"slices"
+)
+
+// TriggerWhen is which ends of an upstream job start a triggered flow.
+type TriggerWhen int
+
+const (
+	OnSuccess TriggerWhen = iota
+	// OnFailure matches jobs that failed or timed out.
+	OnFailure
+	// OnCompletion matches jobs that ended in any way.
+	OnCompletion
+)
+
+func (w TriggerWhen) String() string {
+	switch w {
+	case OnSuccess:
+		return "succeeded"
+	case OnFailure:
+		return "failed"
+	case OnCompletion:
+		return "completed"
+	default:
+		return "unknown"
+	}
+}
+
+// Matches reports whether a job that ended in state sets off the trigger.
+func (w TriggerWhen) Matches(state JobState) bool {
+	if !state.Finished() {
+		return false
+	}
+
+	switch w {
+	case OnSuccess:
+		return state == JobSucceeded
+	case OnFailure:
+		return state == JobFailed || state == JobTimedOut
+	case OnCompletion:
+		return true
+	default:
+		return false
+	}
+}
+
+// Trigger starts a flow when a job of another flow ends.
+type Trigger struct {
+	Flow FlowId
+	When TriggerWhen
+}
+
+// triggerChain returns the flows of a job and of every job that led to it
+// through triggers.
+func (s *JobEngine) triggerChain(id JobId) []FlowId {
+	chain := make([]FlowId, 0)
+	for id != "" {
+		info, ok := s.Jobs.Read(id)
+		if !ok || slices.Contains(chain, info.FlowId) {
+			break
+		}
+		chain = append(chain, info.FlowId)
+		id = info.TriggeredBy
+	}
+	return chain
+}
+
+// startTriggered starts a job of each flow triggered by how a job ended.
+// Flows already in the chain of jobs that led to this one aren't started
+// again, so flows that trigger each other don't loop forever.
+func (s *JobEngine) startTriggered(id JobId, info JobInfo) {
+	var chain []FlowId
+
+	for flowId, flow := range s.Flows.Snapshot() {
+		triggered := slices.ContainsFunc(flow.Triggers, func(trigger Trigger) bool {
+			return trigger.Flow == info.FlowId && trigger.When.Matches(info.State)
+		})
+		if !triggered {
+			continue
+		}
+
+		if chain == nil {
+			chain = s.triggerChain(id)
+		}
+		if slices.Contains(chain, flowId) {
+			log.Printf("Not triggering %s from job %s: it already ran earlier in the chain", flowId, id)
+			continue
+		}
+
+		log.Printf("Job %s of %s %s, triggering %s", id, info.FlowId, info.State, flowId)
+		if _, err := s.StartJobWithOptions(flowId, JobOptions{TriggeredBy: id}); err != nil {
+			log.Printf("Failed to start triggered job of %s: %v", flowId, err)
+		}
+	}
+}
@@ -1,16 +1,69 @@
 package engine
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
 type FlowId string
 type JobId string
 
 type Flow struct {
-	Id       FlowId
+	Id FlowId
+	// Project is the name of the project the flow belongs to, if any.
+	Project  string
 	Steps    []Step
 	Schedule *FlowSchedule
+	// Catchup is what the schedule does about runs it missed.
+	Catchup Catchup
+	// MaxConcurrent limits how many jobs of the flow may run at once, or 0
+	// for no limit.
+	MaxConcurrent int
+	// Timeout limits how long a job of the flow may run for, or 0 for no
+	// limit.
+	Timeout time.Duration
+	// Retry reruns the whole flow when a step fails, or is nil to not retry.
+	Retry *RetryPolicy
+	// Source is the sokofile the flow was loaded from, or empty if it was
+	// defined at runtime.
+	Source string
+	// Inputs are the values each job of the flow is started with, which its
+	// steps can refer to.
+	Inputs []Input
+	// Triggers start the flow when jobs of other flows end.
+	Triggers []Trigger
 }
 
 type Step struct {
+	// Id names the step for other steps to refer to, and may be empty.
+	Id   string
 	Args []string
+	// Dir is the directory the step runs in, or empty to use the daemon's.
+	Dir string
+	// Timeout limits how long the step may run for, or 0 for no limit.
+	Timeout time.Duration
+	// Retry reruns the step when it fails, or is nil to not retry.
+	Retry *RetryPolicy
+	// Env is added to the environment the step inherits from the daemon.
+	Env map[string]string
+	// Secrets maps environment variables to the names of secrets whose
+	// values they are set to.
+	Secrets map[string]string
+	// Outputs are the names of values the step may set for later steps.
+	Outputs []string
+	// Needs are the indices of the steps that must succeed before this one
+	// runs. Steps with no needs run as soon as the job starts, but if Needs
+	// is nil the step needs the one before it.
+	Needs []int
+	// If is the condition the step runs under once the steps it needs have
+	// finished, or empty to run only if they all succeeded and no step has
+	// failed.
+	If string
+	// ContinueOnError lets the job go on as though the step succeeded when it
+	// fails.
+	ContinueOnError bool
 }
 
 type JobState int
@@ -20,8 +73,16 @@ const (
 	JobRunning
 	JobSucceeded
 	JobFailed
+	JobInterrupted
+	JobCancelled
+	JobTimedOut
+	// JobSkipped is only used for steps, which are skipped when their
+	// condition does not hold.
+	JobSkipped
 )
 
+var jobStates = []JobState{JobPending, JobRunning, JobSucceeded, JobFailed, JobInterrupted, JobCancelled, JobTimedOut, JobSkipped}
+
 func (s JobState) String() string {
 	switch s {
 	case JobPending:
@@ -32,14 +93,59 @@ func (s JobState) String() string {
 		return "succeeded"
 	case JobFailed:
 		return "failed"
+	case JobInterrupted:
+		return "interrupted"
+	case JobCancelled:
+		return "cancelled"
+	case JobTimedOut:
+		return "timed_out"
+	case JobSkipped:
+		return "skipped"
 	default:
 		return "unknown"
 	}
 }
 
+// Finished reports whether a job in this state will never run again.
+func (s JobState) Finished() bool {
+	return s != JobPending && s != JobRunning
+}
+
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *JobState) UnmarshalText(text []byte) error {
+	state, ok := ParseJobState(string(text))
+	if !ok {
+		return fmt.Errorf("unknown job state %q", text)
+	}
+	*s = state
+	return nil
+}
+
+// ParseJobState returns the state with the given name.
+func ParseJobState(name string) (JobState, bool) {
+	for _, state := range jobStates {
+		if state.String() == name {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
 type Job struct {
-	Id    JobId
-	Steps []Step
+	Id            JobId
+	FlowId        FlowId
+	Steps         []Step
+	MaxConcurrent int
+	Timeout       time.Duration
+	Retry         *RetryPolicy
+	Inputs        map[string]string
+	Project       string
+	ScheduledAt   time.Time
+	ctx           context.Context
+	secrets       SecretStore
 }
 
 type JobInfo struct {
@@ -47,11 +153,128 @@ type JobInfo struct {
 	State       JobState
 	CurrentStep int
 	Steps       []StepInfo
+	// Inputs are the values the job's inputs were given.
+	Inputs map[string]string
+	// ScheduledAt is the time the schedule started the job for, or zero if it
+	// was started some other way.
+	ScheduledAt time.Time
+	// TriggeredBy is the job whose end started this one through a trigger, if
+	// any.
+	TriggeredBy JobId
+	QueuedAt    time.Time
+	// StartedAt and FinishedAt are zero until the job starts and finishes.
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Duration is how long the job ran for, or 0 if it has not finished.
+func (j JobInfo) Duration() time.Duration {
+	return elapsed(j.StartedAt, j.FinishedAt)
 }
 
 type StepInfo struct {
-	Input  string
-	Output []byte
+	Id    string
+	State JobState
+	Input string
+	// Lines is the output of every attempt at the step, in order.
+	Lines    []OutputLine
+	Attempts []StepAttempt
+	// Outputs are the values the step's latest attempt set for its outputs.
+	Outputs map[string]string
+	// StartedAt is when the step was first tried, and FinishedAt when its
+	// latest attempt ended, so retries count towards its duration.
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Duration is how long the step ran for, or 0 if it has not finished.
+func (s StepInfo) Duration() time.Duration {
+	return elapsed(s.StartedAt, s.FinishedAt)
+}
+
+// Result returns the latest attempt at the step, if it has been tried.
+func (s StepInfo) Result() (StepAttempt, bool) {
+	if len(s.Attempts) == 0 {
+		return StepAttempt{}, false
+	}
+	return s.Attempts[len(s.Attempts)-1], true
+}
+
+// StepAttempt records how one attempt at running a step ended.
+type StepAttempt struct {
+	State JobState
+	// ExitCode is -1 if the step did not exit normally.
+	ExitCode int
+	// Signal names the signal that killed the step, if any.
+	Signal     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (a StepAttempt) Duration() time.Duration {
+	return elapsed(a.StartedAt, a.FinishedAt)
+}
+
+func elapsed(start time.Time, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// Output returns the step's output as a single string.
+func (s StepInfo) Output() string {
+	var b strings.Builder
+	for _, line := range s.Lines {
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+type OutputStream int
+
+const (
+	Stdout OutputStream = iota
+	Stderr
+	// System carries messages from soko itself, such as why a step failed.
+	System
+)
+
+func (s OutputStream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	case System:
+		return "system"
+	default:
+		return "unknown"
+	}
+}
+
+func (s OutputStream) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *OutputStream) UnmarshalText(text []byte) error {
+	for _, stream := range []OutputStream{Stdout, Stderr, System} {
+		if stream.String() == string(text) {
+			*s = stream
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output stream %q", text)
+}
+
+// OutputLine is a line of step output, stamped with when it began.
+type OutputLine struct {
+	Time   time.Time
+	Stream OutputStream
+	Text   string
+	// Attempt is the index of the attempt at the step that wrote the line.
+	Attempt int
 }
 
 type jobUpdate interface {
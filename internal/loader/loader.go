@@ -0,0 +1,339 @@
+package loader
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/fourls/soko/internal/crud"
+	"github.com/fourls/soko/internal/engine"
+	"github.com/fourls/soko/internal/sokofile"
+)
+
+func scheduleToEngine(schedule *sokofile.FlowSchedule, location *time.Location) (*engine.FlowSchedule, error) {
+	if schedule == nil {
+		return nil, nil
+	}
+
+	res := engine.FlowSchedule{Location: location}
+	var err error
+	if res.Minutes, err = schedule.Minutes(); err != nil {
+		return nil, err
+	}
+	if res.Hours, err = schedule.Hours(); err != nil {
+		return nil, err
+	}
+	if res.DaysOfMonth, err = schedule.DaysOfMonth(); err != nil {
+		return nil, err
+	}
+	if res.Months, err = schedule.Months(); err != nil {
+		return nil, err
+	}
+	if res.Days, err = schedule.Days(); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+var inputTypes = map[string]engine.InputType{
+	"":       engine.InputString,
+	"string": engine.InputString,
+	"bool":   engine.InputBool,
+	"choice": engine.InputChoice,
+}
+
+// inputsToEngine converts a flow's inputs, ordered by name.
+func inputsToEngine(inputs map[string]sokofile.Input) []engine.Input {
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	res := make([]engine.Input, 0, len(inputs))
+	for _, name := range slices.Sorted(maps.Keys(inputs)) {
+		input := inputs[name]
+		res = append(res, engine.Input{
+			Name:        name,
+			Type:        inputTypes[input.Type],
+			Description: input.Description,
+			Default:     input.Default,
+			Options:     input.Options,
+		})
+	}
+	return res
+}
+
+var catchupPolicies = map[string]engine.CatchupPolicy{
+	"":     engine.CatchupSkip,
+	"skip": engine.CatchupSkip,
+	"once": engine.CatchupOnce,
+	"all":  engine.CatchupAll,
+}
+
+var triggerWhens = map[string]engine.TriggerWhen{
+	"":          engine.OnSuccess,
+	"succeeded": engine.OnSuccess,
+	"failed":    engine.OnFailure,
+	"completed": engine.OnCompletion,
+}
+
+func triggersToEngine(project *sokofile.Project, triggers []sokofile.Trigger) []engine.Trigger {
+	if len(triggers) == 0 {
+		return nil
+	}
+
+	res := make([]engine.Trigger, len(triggers))
+	for i, trigger := range triggers {
+		res[i] = engine.Trigger{
+			Flow: engine.FlowId(project.TriggerFlow(trigger)),
+			When: triggerWhens[trigger.When],
+		}
+	}
+	return res
+}
+
+func retryToEngine(retry *sokofile.Retry) *engine.RetryPolicy {
+	if retry == nil {
+		return nil
+	}
+
+	backoff := engine.BackoffFixed
+	if retry.Backoff == "exponential" {
+		backoff = engine.BackoffExponential
+	}
+
+	return &engine.RetryPolicy{
+		MaxAttempts: retry.MaxAttempts,
+		Backoff:     backoff,
+		Delay:       retry.Delay,
+		MaxDelay:    retry.MaxDelay,
+		ExitCodes:   retry.ExitCodes,
+	}
+}
+
+// FlowToEngine converts a flow of a project into the form the engine runs.
+// The project provides defaults for the flow, and may be empty for flows
+// defined on their own.
+func FlowToEngine(project *sokofile.Project, id engine.FlowId, flow sokofile.Flow) (engine.Flow, error) {
+	ids := make(map[string]int, len(flow.Steps))
+	for i, step := range flow.Steps {
+		if step.Id != "" {
+			ids[step.Id] = i
+		}
+	}
+
+	steps := make([]engine.Step, len(flow.Steps))
+	for i, step := range flow.Steps {
+		var needs []int
+		if step.Needs != nil {
+			needs = make([]int, len(step.Needs))
+			for j, name := range step.Needs {
+				need, ok := ids[name]
+				if !ok {
+					return engine.Flow{}, fmt.Errorf("flow %s: step %d needs unknown step %s", id, i+1, name)
+				}
+				needs[j] = need
+			}
+		}
+
+		steps[i] = engine.Step{
+			Id:              step.Id,
+			Needs:           needs,
+			Args:            project.StepArgs(flow, step),
+			Dir:             project.StepWorkdir(flow, step),
+			Timeout:         step.Timeout,
+			Retry:           retryToEngine(step.Retry),
+			Env:             project.StepEnv(flow, step),
+			Secrets:         project.StepSecrets(flow, step),
+			Outputs:         step.Outputs,
+			If:              step.If,
+			ContinueOnError: step.ContinueOnError,
+		}
+	}
+
+	location, err := project.Location(flow)
+	if err != nil {
+		return engine.Flow{}, fmt.Errorf("flow %s: %w", id, err)
+	}
+
+	schedule, err := scheduleToEngine(flow.Schedule, location)
+	if err != nil {
+		return engine.Flow{}, fmt.Errorf("flow %s: %w", id, err)
+	}
+
+	catchup := engine.Catchup{
+		Policy: catchupPolicies[flow.Catchup],
+		Limit:  flow.CatchupLimit,
+	}
+
+	res := engine.Flow{
+		Id:            id,
+		Project:       project.Name,
+		Steps:         steps,
+		Schedule:      schedule,
+		Catchup:       catchup,
+		MaxConcurrent: flow.MaxConcurrent,
+		Timeout:       flow.Timeout,
+		Retry:         retryToEngine(flow.Retry),
+		Inputs:        inputsToEngine(flow.Inputs),
+		Triggers:      triggersToEngine(project, flow.On),
+	}
+	if err := res.Validate(); err != nil {
+		return engine.Flow{}, fmt.Errorf("flow %s: %w", id, err)
+	}
+	return res, nil
+}
+
+func sokofileToFlows(path string, project *sokofile.Project) (map[engine.FlowId]engine.Flow, error) {
+	flows := make(map[engine.FlowId]engine.Flow, len(project.Flows))
+
+	for key, value := range project.Flows {
+		id := engine.FlowId(project.Name + "." + key)
+		flow, err := FlowToEngine(project, id, value)
+		if err != nil {
+			return nil, err
+		}
+
+		flow.Source = path
+		flows[id] = flow
+	}
+
+	return flows, nil
+}
+
+type loadedFile struct {
+	modTime time.Time
+	size    int64
+	flows   []engine.FlowId
+}
+
+// Loader keeps a flow store in sync with the sokofiles beneath a directory.
+type Loader struct {
+	root   string
+	flows  *crud.Crud[engine.FlowId, engine.Flow]
+	files  map[string]loadedFile
+	owners map[engine.FlowId]string
+}
+
+func New(root string, flows *crud.Crud[engine.FlowId, engine.Flow]) *Loader {
+	return &Loader{
+		root:   root,
+		flows:  flows,
+		files:  make(map[string]loadedFile),
+		owners: make(map[engine.FlowId]string),
+	}
+}
+
+// Load scans the projects directory once, applying any added, changed or
+// removed sokofiles to the flow store. Files that fail to parse keep the
+// flows from their last successful load.
+func (l *Loader) Load() {
+	paths, err := sokofile.Discover(l.root)
+	if err != nil {
+		log.Printf("Failed to search %s for projects: %v", l.root, err)
+		return
+	}
+
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		seen[path] = true
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Failed to stat project %s: %v", path, err)
+			continue
+		}
+
+		previous, ok := l.files[path]
+		if ok && previous.modTime.Equal(stat.ModTime()) && previous.size == stat.Size() {
+			continue
+		}
+
+		project, err := sokofile.Parse(path)
+		var flows map[engine.FlowId]engine.Flow
+		if err == nil {
+			flows, err = sokofileToFlows(path, project)
+		}
+		if err != nil {
+			log.Printf("Failed to load project %s: %v", path, err)
+			// don't retry until the file changes again
+			previous.modTime = stat.ModTime()
+			previous.size = stat.Size()
+			l.files[path] = previous
+			continue
+		}
+
+		l.files[path] = loadedFile{
+			modTime: stat.ModTime(),
+			size:    stat.Size(),
+			flows:   l.apply(path, flows),
+		}
+		log.Printf("Loaded project %s from %s", project.Name, path)
+	}
+
+	for path := range l.files {
+		if !seen[path] {
+			l.apply(path, nil)
+			delete(l.files, path)
+			log.Printf("Unloaded project %s", path)
+		}
+	}
+}
+
+// apply replaces the flows owned by a file with the given set, returning the
+// ids the file now owns.
+func (l *Loader) apply(path string, flows map[engine.FlowId]engine.Flow) []engine.FlowId {
+	owned := make([]engine.FlowId, 0, len(flows))
+
+	for id, flow := range flows {
+		owner, ok := l.owners[id]
+		if ok && owner != path {
+			log.Printf("Skipping flow %s from %s: already defined by %s", id, path, owner)
+			continue
+		}
+
+		if !ok {
+			if !l.flows.Create(id, flow) {
+				log.Printf("Skipping flow %s from %s: a flow with that id already exists", id, path)
+				continue
+			}
+			log.Printf("Added flow %s", id)
+		} else if current, _ := l.flows.Read(id); !reflect.DeepEqual(current, flow) {
+			if !l.flows.Update(id, func(engine.Flow) engine.Flow { return flow }) {
+				l.flows.Create(id, flow)
+			}
+			log.Printf("Updated flow %s", id)
+		}
+
+		l.owners[id] = path
+		owned = append(owned, id)
+	}
+
+	for _, id := range l.files[path].flows {
+		if _, ok := flows[id]; !ok && l.owners[id] == path {
+			l.flows.Delete(id)
+			delete(l.owners, id)
+			log.Printf("Removed flow %s", id)
+		}
+	}
+
+	return owned
+}
+
+// Watch reloads the projects directory every interval until quit is signalled.
+func (l *Loader) Watch(interval time.Duration, quit chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			l.Load()
+		}
+	}
+}
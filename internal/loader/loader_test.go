@@ -0,0 +1,277 @@
+package loader_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fourls/soko/internal/crud"
+	"github.com/fourls/soko/internal/engine"
+	"github.com/fourls/soko/internal/loader"
+	"github.com/fourls/soko/internal/sokofile"
+)
+
+func writeProject(t *testing.T, path string, contents string, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderReload(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "soko.yml")
+	now := time.Now()
+
+	flows := crud.New[engine.FlowId, engine.Flow]()
+	defer flows.Close()
+	l := loader.New(root, &flows)
+
+	writeProject(t, path, `
+name: test
+flows:
+  a:
+    steps:
+      - cmd: ["true"]
+  b:
+    steps:
+      - cmd: ["true"]
+`, now)
+	l.Load()
+
+	if snapshot := flows.Snapshot(); len(snapshot) != 2 {
+		t.Fatalf("got: %d flows after first load, expected: 2", len(snapshot))
+	}
+
+	writeProject(t, path, `
+name: test
+flows:
+  a:
+    steps:
+      - cmd: ["false"]
+`, now.Add(time.Second))
+	l.Load()
+
+	flow, ok := flows.Read("test.a")
+	if !ok || len(flow.Steps) != 1 || flow.Steps[0].Args[0] != "false" {
+		t.Fatalf("got: %v, %v for updated flow, expected the new steps", flow, ok)
+	}
+	if _, ok := flows.Read("test.b"); ok {
+		t.Fatalf("got: removed flow still present")
+	}
+
+	writeProject(t, path, "flows: [", now.Add(2*time.Second))
+	l.Load()
+
+	if _, ok := flows.Read("test.a"); !ok {
+		t.Fatalf("got: flow removed after invalid edit, expected it to be kept")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Load()
+
+	if snapshot := flows.Snapshot(); len(snapshot) != 0 {
+		t.Fatalf("got: %d flows after deleting project, expected: 0", len(snapshot))
+	}
+}
+
+func TestLoaderConflictingIds(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+
+	flows := crud.New[engine.FlowId, engine.Flow]()
+	defer flows.Close()
+	l := loader.New(root, &flows)
+
+	project := `
+name: test
+flows:
+  a:
+    steps:
+      - cmd: ["true"]
+`
+	writeProject(t, filepath.Join(root, "one/soko.yml"), project, now)
+	writeProject(t, filepath.Join(root, "two/soko.yml"), project, now)
+	l.Load()
+
+	if err := os.Remove(filepath.Join(root, "two/soko.yml")); err != nil {
+		t.Fatal(err)
+	}
+	l.Load()
+
+	if _, ok := flows.Read("test.a"); !ok {
+		t.Fatalf("got: flow removed by its duplicate, expected it to be kept")
+	}
+}
+
+func TestLoaderRejectsUnknownVariables(t *testing.T) {
+	root := t.TempDir()
+
+	flows := crud.New[engine.FlowId, engine.Flow]()
+	defer flows.Close()
+	l := loader.New(root, &flows)
+
+	writeProject(t, filepath.Join(root, "soko.yml"), `
+name: test
+flows:
+  a:
+    inputs:
+      target: {}
+    steps:
+      - cmd: ["echo", "${{ inputs.target }} ${{ project.name }}"]
+      - cmd: ["echo", "${{ steps.1.output }}"]
+`, time.Now())
+	writeProject(t, filepath.Join(root, "other", "soko.yml"), `
+name: other
+flows:
+  b:
+    steps:
+      - cmd: ["echo", "${{ inputs.target }}"]
+`, time.Now())
+	l.Load()
+
+	flow, ok := flows.Read("test.a")
+	if !ok || flow.Project != "test" {
+		t.Fatalf("got: %+v, %v, expected test.a to load", flow, ok)
+	}
+	if _, ok := flows.Read("other.b"); ok {
+		t.Fatalf("got: other.b loaded, expected its unknown variable to be rejected")
+	}
+}
+
+func TestLoaderTriggers(t *testing.T) {
+	root := t.TempDir()
+
+	flows := crud.New[engine.FlowId, engine.Flow]()
+	defer flows.Close()
+	l := loader.New(root, &flows)
+
+	writeProject(t, filepath.Join(root, "soko.yml"), `
+name: test
+flows:
+  build:
+    steps:
+      - cmd: ["true"]
+  deploy:
+    on:
+      - flow: build
+      - flow: other.release
+        when: failed
+    steps:
+      - cmd: ["true"]
+`, time.Now())
+	l.Load()
+
+	flow, ok := flows.Read("test.deploy")
+	expected := []engine.Trigger{
+		{Flow: "test.build", When: engine.OnSuccess},
+		{Flow: "other.release", When: engine.OnFailure},
+	}
+	if !ok || !slices.Equal(flow.Triggers, expected) {
+		t.Fatalf("got: %+v, %v, expected triggers: %+v", flow.Triggers, ok, expected)
+	}
+}
+
+func TestLoaderUnnamedProjects(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+
+	flows := crud.New[engine.FlowId, engine.Flow]()
+	defer flows.Close()
+	l := loader.New(root, &flows)
+
+	project := `
+flows:
+  build:
+    steps:
+      - cmd: ["true"]
+  deploy:
+    on:
+      - flow: build
+    steps:
+      - cmd: ["true"]
+`
+	writeProject(t, filepath.Join(root, "one/soko.yml"), project, now)
+	writeProject(t, filepath.Join(root, "two/soko.yml"), project, now)
+	l.Load()
+
+	for _, name := range []string{"one", "two"} {
+		if _, ok := flows.Read(engine.FlowId(name + ".build")); !ok {
+			t.Fatalf("got: no flow %s.build, expected the project to be named after its directory", name)
+		}
+
+		flow, ok := flows.Read(engine.FlowId(name + ".deploy"))
+		expected := []engine.Trigger{{Flow: engine.FlowId(name + ".build"), When: engine.OnSuccess}}
+		if !ok || !slices.Equal(flow.Triggers, expected) {
+			t.Fatalf("got: %+v, %v, expected triggers: %+v", flow.Triggers, ok, expected)
+		}
+	}
+}
+
+func TestLoaderPassesScriptVarsThroughEnv(t *testing.T) {
+	dir := t.TempDir()
+	project := &sokofile.Project{Name: "test"}
+	definition, err := sokofile.ParseFlow("run", []byte(`
+inputs:
+  target: {}
+steps:
+  - id: version
+    run: echo "::set-output name=tag::$(echo ';touch pwned-output;')"
+    outputs: [tag]
+  - run: echo "${{ inputs.target }}" ${{ steps.version.outputs.tag }}
+    workdir: `+dir+`
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flow, err := loader.FlowToEngine(project, "test.run", *definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobEngine, err := engine.New(engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobEngine.Close()
+	jobEngine.Flows.Create(flow.Id, flow)
+
+	hostile := `x"; touch pwned-input; echo "$(touch pwned-subst)`
+	id, err := jobEngine.StartJobWithOptions(flow.Id, engine.JobOptions{
+		Inputs: map[string]string{"target": hostile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info engine.JobInfo
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if info, _ = jobEngine.GetJob(id); info.State.Finished() {
+			break
+		}
+	}
+	if info.State != engine.JobSucceeded {
+		t.Fatalf("got: %v, expected the job to succeed", info.State)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("got: %v created, expected the values not to run as commands", entries)
+	}
+	if output, expected := info.Steps[1].Output(), hostile+" ;touch pwned-output;\n"; output != expected {
+		t.Fatalf("got: %q, expected: %q", output, expected)
+	}
+}
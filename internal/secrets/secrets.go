@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Store holds secret values for steps to reference by name, so they never
+// need to be written into a sokofile.
+type Store struct {
+	values map[string]string
+}
+
+// Load reads secrets from file, if it is not empty, and from environment
+// variables whose names start with envPrefix. Prefixed variables are removed
+// from the environment once read so steps do not inherit them. Secrets in
+// the file take precedence.
+//
+// The file holds one NAME=value pair per line. Blank lines and lines starting
+// with # are ignored.
+func Load(file string, envPrefix string) (*Store, error) {
+	values := make(map[string]string)
+
+	if envPrefix != "" {
+		for _, entry := range os.Environ() {
+			key, value, _ := strings.Cut(entry, "=")
+			if name, ok := strings.CutPrefix(key, envPrefix); ok && name != "" {
+				values[name] = value
+				os.Unsetenv(key)
+			}
+		}
+	}
+
+	if file != "" {
+		if err := readFile(file, values); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Store{values: values}, nil
+}
+
+func readFile(file string, values map[string]string) error {
+	// a file that was asked for but is missing is an error, so a typo in
+	// its path doesn't leave every secret unset
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("%s:%d: expected NAME=value", file, line)
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+
+	return scanner.Err()
+}
+
+func (s *Store) Lookup(name string) (string, bool) {
+	value, ok := s.values[name]
+	return value, ok
+}
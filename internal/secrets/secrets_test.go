@@ -0,0 +1,70 @@
+package secrets_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fourls/soko/internal/secrets"
+)
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets")
+	contents := `# deploy credentials
+TOKEN=from-file
+SPACED = padded value
+
+SHARED=file wins
+`
+	if err := os.WriteFile(file, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SOKO_TEST_SECRET_ENV", "from-env")
+	t.Setenv("SOKO_TEST_SECRET_SHARED", "env loses")
+
+	store, err := secrets.Load(file, "SOKO_TEST_SECRET_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"TOKEN":  "from-file",
+		"SPACED": "padded value",
+		"ENV":    "from-env",
+		"SHARED": "file wins",
+	}
+	for name, value := range expected {
+		if result, ok := store.Lookup(name); !ok || result != value {
+			t.Fatalf("got: %q, %v for %s, expected: %q", result, ok, name, value)
+		}
+	}
+
+	if _, ok := store.Lookup("MISSING"); ok {
+		t.Fatalf("got: a value for a missing secret")
+	}
+	if _, ok := os.LookupEnv("SOKO_TEST_SECRET_ENV"); ok {
+		t.Fatalf("got: secret still in the environment, expected it to be removed")
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(file, []byte("TOKEN\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := secrets.Load(file, ""); err == nil {
+		t.Fatalf("got: no error, expected a line without = to be rejected")
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets")
+
+	if _, err := secrets.Load(file, ""); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got: %v, expected: %v", err, fs.ErrNotExist)
+	}
+}
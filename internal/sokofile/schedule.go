@@ -0,0 +1,284 @@
+package sokofile
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlowSchedule describes when a flow runs, either as a standard five-field
+// cron expression or as individual fields, but not both. Empty fields match
+// every value.
+type FlowSchedule struct {
+	Cron            string `yaml:"cron"`
+	MinuteValue     string `yaml:"minute"`
+	HourValue       string `yaml:"hour"`
+	DayOfMonthValue string `yaml:"day_of_month"`
+	MonthValue      string `yaml:"month"`
+	DayValue        string `yaml:"day"`
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+const (
+	cronMinute = iota
+	cronHour
+	cronDayOfMonth
+	cronMonth
+	cronDay
+)
+
+type scheduleField struct {
+	name     string
+	min, max int
+	names    func(string) (int, bool)
+}
+
+var (
+	minuteField     = scheduleField{name: "minute", min: 0, max: 59}
+	hourField       = scheduleField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = scheduleField{name: "day_of_month", min: 1, max: 31}
+	monthField      = scheduleField{name: "month", min: 1, max: 12, names: monthName}
+	// 7 is accepted as an alias for Sunday, as in cron
+	dayField     = scheduleField{name: "day", min: 0, max: 7, names: weekdayName}
+	cronDayField = scheduleField{name: "day", min: 0, max: 7, names: cronWeekdayName}
+)
+
+func monthName(value string) (int, bool) {
+	for i := time.January; i <= time.December; i++ {
+		name := i.String()
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			return int(i), true
+		}
+	}
+	return 0, false
+}
+
+func weekdayName(value string) (int, bool) {
+	for i := range 7 {
+		if strings.EqualFold(value, time.Weekday(i).String()) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func cronWeekdayName(value string) (int, bool) {
+	for i := range 7 {
+		name := time.Weekday(i).String()
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (f scheduleField) convert(value string) (int, error) {
+	if f.names != nil {
+		if num, ok := f.names(value); ok {
+			return num, nil
+		}
+	}
+
+	num, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", f.name, value)
+	}
+	if num < f.min || num > f.max {
+		return 0, fmt.Errorf("%s %d is out of range %d-%d", f.name, num, f.min, f.max)
+	}
+	return num, nil
+}
+
+// parseItem expands a single comma-separated item of a schedule field, which
+// may be a value, a range (1-5), or either of those or * with a step (*/15).
+func (f scheduleField) parseItem(item string) ([]int, error) {
+	step := 1
+	hasStep := false
+	if base, stepValue, ok := strings.Cut(item, "/"); ok {
+		num, err := strconv.Atoi(stepValue)
+		if err != nil || num < 1 {
+			return nil, fmt.Errorf("invalid step %q in %q", stepValue, item)
+		}
+		item, step, hasStep = base, num, true
+	}
+
+	var start, end int
+	if item == "*" {
+		start, end = f.min, f.max
+	} else if low, high, ok := strings.Cut(item, "-"); ok {
+		var err error
+		if start, err = f.convert(low); err != nil {
+			return nil, err
+		}
+		if end, err = f.convert(high); err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, fmt.Errorf("range %q starts after it ends", item)
+		}
+	} else {
+		var err error
+		if start, err = f.convert(item); err != nil {
+			return nil, err
+		}
+		end = start
+		if hasStep {
+			end = f.max
+		}
+	}
+
+	res := make([]int, 0, (end-start)/step+1)
+	for i := start; i <= end; i += step {
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+func (f scheduleField) parse(value string) ([]int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "*" {
+		return nil, nil
+	}
+
+	res := make([]int, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("empty item in %s %q", f.name, value)
+		}
+
+		nums, err := f.parseItem(item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, nums...)
+	}
+
+	return res, nil
+}
+
+// cronFields splits the cron expression into its five fields, expanding
+// macros such as @daily.
+func (s FlowSchedule) cronFields() ([]string, error) {
+	expr := strings.TrimSpace(s.Cron)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	} else if strings.HasPrefix(expr, "@") {
+		return nil, fmt.Errorf("unknown cron macro %q", expr)
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q has %d fields, expected 5", s.Cron, len(fields))
+	}
+	return fields, nil
+}
+
+func (s FlowSchedule) value(cronIndex int, value string) (string, error) {
+	if s.Cron == "" {
+		return value, nil
+	}
+
+	fields, err := s.cronFields()
+	if err != nil {
+		return "", err
+	}
+	return fields[cronIndex], nil
+}
+
+func (s FlowSchedule) parse(field scheduleField, cronIndex int, value string) ([]int, error) {
+	value, err := s.value(cronIndex, value)
+	if err != nil {
+		return nil, err
+	}
+	return field.parse(value)
+}
+
+func (s FlowSchedule) Minutes() ([]int, error) {
+	return s.parse(minuteField, cronMinute, s.MinuteValue)
+}
+
+func (s FlowSchedule) Hours() ([]int, error) {
+	return s.parse(hourField, cronHour, s.HourValue)
+}
+
+func (s FlowSchedule) DaysOfMonth() ([]int, error) {
+	return s.parse(dayOfMonthField, cronDayOfMonth, s.DayOfMonthValue)
+}
+
+func (s FlowSchedule) Months() ([]time.Month, error) {
+	months, err := s.parse(monthField, cronMonth, s.MonthValue)
+	if months == nil {
+		return nil, err
+	}
+
+	res := make([]time.Month, len(months))
+	for i, month := range months {
+		res[i] = time.Month(month)
+	}
+	return res, nil
+}
+
+func (s FlowSchedule) Days() ([]time.Weekday, error) {
+	field := dayField
+	if s.Cron != "" {
+		field = cronDayField
+	}
+
+	days, err := s.parse(field, cronDay, s.DayValue)
+	if days == nil {
+		return nil, err
+	}
+
+	res := make([]time.Weekday, 0, len(days))
+	for _, day := range days {
+		weekday := time.Weekday(day % 7)
+		if !slices.Contains(res, weekday) {
+			res = append(res, weekday)
+		}
+	}
+	return res, nil
+}
+
+// Validate checks every field of the schedule, returning the yaml key of the
+// first invalid field along with the problem.
+func (s FlowSchedule) Validate() (string, error) {
+	checks := []struct {
+		key   string
+		value string
+		check func() error
+	}{
+		{"minute", s.MinuteValue, func() error { _, err := s.Minutes(); return err }},
+		{"hour", s.HourValue, func() error { _, err := s.Hours(); return err }},
+		{"day_of_month", s.DayOfMonthValue, func() error { _, err := s.DaysOfMonth(); return err }},
+		{"month", s.MonthValue, func() error { _, err := s.Months(); return err }},
+		{"day", s.DayValue, func() error { _, err := s.Days(); return err }},
+	}
+
+	for _, c := range checks {
+		if s.Cron != "" && c.value != "" {
+			return c.key, errors.New("can't be set along with cron")
+		}
+	}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			if s.Cron != "" {
+				return "cron", err
+			}
+			return c.key, err
+		}
+	}
+	return "", nil
+}
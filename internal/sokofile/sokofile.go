@@ -1,9 +1,15 @@
 package sokofile
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,69 +17,382 @@ import (
 )
 
 type Project struct {
-	Name  string          `yaml:"name"`
-	Flows map[string]Flow `yaml:"flows"`
+	// Name prefixes the ids of the project's flows. It defaults to the name
+	// of the directory containing the sokofile.
+	Name     string            `yaml:"name"`
+	Timezone string            `yaml:"timezone"`
+	Env      map[string]string `yaml:"env"`
+	Secrets  map[string]string `yaml:"secrets"`
+	Workdir  string            `yaml:"workdir"`
+	Shell    []string          `yaml:"shell"`
+	Flows    map[string]Flow   `yaml:"flows"`
+
+	// dir is the directory containing the sokofile.
+	dir string
 }
 
 type Flow struct {
-	Steps    []FlowStep    `yaml:"steps"`
-	Schedule *FlowSchedule `yaml:"schedule"`
+	Steps         []FlowStep        `yaml:"steps"`
+	Schedule      *FlowSchedule     `yaml:"schedule"`
+	Timezone      string            `yaml:"timezone"`
+	MaxConcurrent int               `yaml:"max_concurrent"`
+	Timeout       time.Duration     `yaml:"timeout"`
+	Retry         *Retry            `yaml:"retry"`
+	Env           map[string]string `yaml:"env"`
+	Secrets       map[string]string `yaml:"secrets"`
+	Workdir       string            `yaml:"workdir"`
+	Shell         []string          `yaml:"shell"`
+	Inputs        map[string]Input  `yaml:"inputs"`
+	On            []Trigger         `yaml:"on"`
+	// Catchup is what the schedule does about runs missed while soko was
+	// down or the host was suspended: skip them, run once for the latest,
+	// or run all of them, up to catchup_limit. It defaults to skip.
+	Catchup      string `yaml:"catchup"`
+	CatchupLimit int    `yaml:"catchup_limit"`
 }
 
-type FlowSchedule struct {
-	MinuteValue string `yaml:"minute"`
-	HourValue   string `yaml:"hour"`
-	DayValue    string `yaml:"day"`
+// Trigger starts a flow when a job of another flow ends.
+type Trigger struct {
+	// Flow names a flow of the same project, or of another project as
+	// project.flow.
+	Flow string `yaml:"flow"`
+	// When is succeeded, failed or completed, which matches any end. It
+	// defaults to succeeded.
+	When string `yaml:"when"`
 }
 
-func parseValue[T any](value string, convert func(string) (T, error)) []T {
-	if value == "*" {
-		return nil
+// TriggerFlow returns the id of the flow a trigger refers to. Names of flows
+// in the project refer to them, and any other name is taken as a full id.
+func (p *Project) TriggerFlow(trigger Trigger) string {
+	if _, ok := p.Flows[trigger.Flow]; ok && p.Name != "" {
+		return p.Name + "." + trigger.Flow
 	}
+	return trigger.Flow
+}
+
+// Input is a value given to a flow each time it runs. Steps refer to it as
+// ${{ inputs.name }}.
+type Input struct {
+	// Type is string, bool or choice. It defaults to string.
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	// Default is used when no value is given. Inputs without one must be
+	// given a value.
+	Default *string `yaml:"default"`
+	// Options are the values a choice may take.
+	Options []string `yaml:"options"`
+}
 
-	values := strings.Split(value, ",")
-	res := make([]T, 0)
+// defaultShell runs the scripts of steps that use run when no shell is set.
+var defaultShell = []string{"sh", "-c"}
 
-	for _, val := range values {
-		num, err := convert(strings.TrimSpace(val))
-		if err == nil {
-			res = append(res, num)
+// StepArgs returns the command a step runs. Steps that give a script with run
+// execute it with the shell of the step, flow or project, falling back to
+// sh -c. Variables in the script are replaced with references to the
+// environment variables StepEnv passes them in, so their values can't be run
+// as commands.
+func (p *Project) StepArgs(flow Flow, step FlowStep) []string {
+	if step.Run == "" {
+		return step.Cmd
+	}
+
+	shell := defaultShell
+	for _, level := range [][]string{p.Shell, flow.Shell, step.Shell} {
+		if level != nil {
+			shell = level
 		}
 	}
+	script, _ := scriptVars(step.Run)
+	return append(slices.Clone(shell), script)
+}
 
-	return res
+// scriptVars replaces each ${{ name }} in a script with a reference to an
+// environment variable, such as ${SOKO_INPUTS_TARGET} for inputs.target,
+// returning the script and the environment variables to set to each
+// variable.
+func scriptVars(script string) (string, map[string]string) {
+	var b strings.Builder
+	var env map[string]string
+	names := make(map[string]string)
+	for {
+		start := strings.Index(script, "${{")
+		end := strings.Index(script[max(start, 0):], "}}")
+		if start < 0 || end < 0 {
+			// an unclosed ${{ is left for the engine to report
+			b.WriteString(script)
+			return b.String(), env
+		}
+		end += start
+
+		name := strings.TrimSpace(script[start+len("${{") : end])
+		key, ok := names[name]
+		if !ok {
+			key = scriptVarKey(name, env)
+			names[name] = key
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[key] = "${{ " + name + " }}"
+		}
+
+		b.WriteString(script[:start])
+		b.WriteString("${" + key + "}")
+		script = script[end+len("}}"):]
+	}
 }
 
-func (s FlowSchedule) Minutes() []int {
-	return parseValue(s.MinuteValue, strconv.Atoi)
+// scriptVarKey returns the environment variable a script variable is passed
+// in, which doesn't clash with any already in env.
+func scriptVarKey(name string, env map[string]string) string {
+	base := "SOKO_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	key := base
+	for i := 2; ; i++ {
+		if _, ok := env[key]; !ok {
+			return key
+		}
+		key = fmt.Sprintf("%s_%d", base, i)
+	}
 }
 
-func (s FlowSchedule) Hours() []int {
-	return parseValue(s.HourValue, strconv.Atoi)
+// StepWorkdir returns the directory a step runs in: the workdir of the step,
+// flow or project, whichever is most specific. Relative workdirs are
+// resolved against the directory containing the sokofile, which is also
+// where steps run when no workdir is set.
+func (p *Project) StepWorkdir(flow Flow, step FlowStep) string {
+	workdir := ""
+	for _, level := range []string{p.Workdir, flow.Workdir, step.Workdir} {
+		if level != "" {
+			workdir = level
+		}
+	}
+
+	if filepath.IsAbs(workdir) {
+		return workdir
+	}
+	return filepath.Join(p.dir, workdir)
+}
+
+// StepEnv returns the environment variables for a step, combining those of
+// the project, flow and step. Later levels override earlier ones. Steps that
+// give a script with run are also passed the variables it refers to.
+func (p *Project) StepEnv(flow Flow, step FlowStep) map[string]string {
+	_, vars := scriptVars(step.Run)
+	return merge(p.Env, flow.Env, step.Env, vars)
+}
+
+// StepSecrets returns which secret each environment variable of a step is
+// set to, combining those of the project, flow and step. Later levels
+// override earlier ones.
+func (p *Project) StepSecrets(flow Flow, step FlowStep) map[string]string {
+	return merge(p.Secrets, flow.Secrets, step.Secrets)
 }
 
-func (s FlowSchedule) Days() []time.Weekday {
-	return parseValue(s.DayValue, func(value string) (time.Weekday, error) {
-		for i := range 7 {
-			if strings.EqualFold(value, time.Weekday(i).String()) {
-				return time.Weekday(i), nil
+func merge(levels ...map[string]string) map[string]string {
+	var res map[string]string
+	for _, level := range levels {
+		for key, value := range level {
+			if res == nil {
+				res = make(map[string]string)
 			}
+			res[key] = value
 		}
-		return 0, errors.New("parse: weekday not found")
-	})
+	}
+	return res
+}
+
+// Location returns the time zone the flow's schedule is evaluated in: the
+// flow's own timezone, falling back to the project's. It returns nil if
+// neither is set.
+func (p *Project) Location(flow Flow) (*time.Location, error) {
+	name := flow.Timezone
+	if name == "" {
+		name = p.Timezone
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return time.LoadLocation(name)
 }
 
+// FlowStep is a single command of a flow, given either as a list of
+// arguments with cmd or as a shell script with run. Its command and env may
+// refer to variables of the job as ${{ name }}, such as ${{ job.id }}. In run
+// scripts these become references to environment variables holding their
+// values, so they expand like any other shell variable, and not at all inside
+// single quotes.
 type FlowStep struct {
-	Cmd []string `yaml:"cmd"`
+	// Id names the step so other steps can need it or refer to its outputs.
+	Id string `yaml:"id"`
+	// Needs are the ids of the steps that must succeed before this one runs,
+	// letting steps that don't need each other run in parallel. Without
+	// needs, a step needs the one before it; needs: [] runs it straight away.
+	Needs   []string          `yaml:"needs"`
+	Cmd     []string          `yaml:"cmd"`
+	Run     string            `yaml:"run"`
+	Shell   []string          `yaml:"shell"`
+	Workdir string            `yaml:"workdir"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Retry   *Retry            `yaml:"retry"`
+	Env     map[string]string `yaml:"env"`
+	Secrets map[string]string `yaml:"secrets"`
+	// Outputs are values the step sets for later steps by writing lines of
+	// the form ::set-output name=<name>::<value>. Steps that need it refer to
+	// them as ${{ steps.<id>.outputs.<name> }}, or by the step's number,
+	// counting from 1.
+	Outputs []string `yaml:"outputs"`
+	// If is a condition the step only runs under, such as always() or
+	// failure() for steps that clean up or report after others fail, or
+	// after the flow is cancelled or times out. By default steps only run
+	// while every step has succeeded.
+	If string `yaml:"if"`
+	// ContinueOnError lets the flow go on as though the step succeeded when
+	// it fails.
+	ContinueOnError bool `yaml:"continue_on_error"`
 }
 
+// Retry describes how a failing step or flow is retried.
+type Retry struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     string        `yaml:"backoff"`
+	Delay       time.Duration `yaml:"delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	ExitCodes   []int         `yaml:"exit_codes"`
+}
+
+// Validate checks the retry policy, returning the yaml key of the first
+// invalid field along with the problem.
+func (r Retry) Validate() (string, error) {
+	switch {
+	case r.MaxAttempts < 1:
+		return "max_attempts", errors.New("must be at least 1")
+	case r.Backoff != "" && r.Backoff != "fixed" && r.Backoff != "exponential":
+		return "backoff", fmt.Errorf("unknown backoff %q, expected fixed or exponential", r.Backoff)
+	case r.Delay < 0:
+		return "delay", errors.New("must not be negative")
+	case r.MaxDelay < 0:
+		return "max_delay", errors.New("must not be negative")
+	}
+	return "", nil
+}
+
+// decode strictly decodes yaml into value, also returning the yaml's node tree
+// so problems can be traced back to a line.
+func decode(data []byte, value any) (*yaml.Node, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(value); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// Parse reads and validates a sokofile. Problems with individual fields are
+// reported as ValidationErrors.
 func Parse(file string) (*Project, error) {
-	f, err := os.Open(file)
+	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
-	var contents Project
-	err = yaml.NewDecoder(f).Decode(&contents)
-	return &contents, err
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+
+	contents := Project{dir: dir}
+	root, err := decode(data, &contents)
+	if errors.Is(err, io.EOF) {
+		return &contents, errors.New("sokofile is empty")
+	} else if err != nil {
+		return &contents, err
+	}
+	if contents.Name == "" {
+		contents.Name = filepath.Base(dir)
+	}
+
+	return &contents, validate(file, root, &contents)
+}
+
+// ParseFlow reads and validates a single flow, written as it would be under
+// flows in a sokofile. As JSON is valid yaml, it may be written as either.
+// Problems with individual fields are reported as ValidationErrors.
+func ParseFlow(name string, data []byte) (*Flow, error) {
+	var flow Flow
+	root, err := decode(data, &flow)
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("flow is empty")
+	} else if err != nil {
+		return nil, err
+	}
+
+	errs := make(ValidationErrors, 0)
+	validateFlow(func(field string, err error, path ...string) {
+		node := lookup(root, path...)
+		errs = append(errs, &ValidationError{
+			Flow:   name,
+			Field:  field,
+			Line:   node.Line,
+			Column: node.Column,
+			Err:    err,
+		})
+	}, flow)
+
+	if len(errs) > 0 {
+		return &flow, errs
+	}
+	return &flow, nil
+}
+
+// IsSokofile reports whether a file name is one sokod should load as a project.
+func IsSokofile(name string) bool {
+	return name == "soko.yml" || strings.HasSuffix(name, ".soko.yml")
+}
+
+// Discover walks root and returns the path of every sokofile beneath it.
+// Hidden directories are skipped, as are entries that can't be read, so one
+// bad directory doesn't hide every other project. It only fails if root
+// itself can't be read.
+func Discover(root string) ([]string, error) {
+	files := make([]string, 0)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Printf("Skipping %s while searching for projects: %v", path, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if IsSokofile(d.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, err
 }
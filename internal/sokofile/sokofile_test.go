@@ -1,7 +1,12 @@
 package sokofile_test
 
 import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,20 +17,35 @@ func TestScheduleMinutes(t *testing.T) {
 	cases := []struct {
 		input    string
 		expected []int
+		valid    bool
 	}{
-		{"*", nil},
-		{"25", []int{25}},
-		{"1,2,3", []int{1, 2, 3}},
-		{"1, 2 , 3", []int{1, 2, 3}},
-		{"1,55,31", []int{1, 55, 31}},
-		{"1,foo, a bar,,", []int{1}},
+		{"*", nil, true},
+		{"25", []int{25}, true},
+		{"1,2,3", []int{1, 2, 3}, true},
+		{"1, 2 , 3", []int{1, 2, 3}, true},
+		{"1,55,31", []int{1, 55, 31}, true},
+		{"1,foo, a bar,,", nil, false},
+		{"", nil, true},
+		{"10-13", []int{10, 11, 12, 13}, true},
+		{"*/15", []int{0, 15, 30, 45}, true},
+		{"5/20", []int{5, 25, 45}, true},
+		{"0-10/5,30", []int{0, 5, 10, 30}, true},
+		{"75", nil, false},
+		{"-1", nil, false},
+		{"50-70", nil, false},
+		{"20-10", nil, false},
+		{"*/0", nil, false},
+		{"1,,2", nil, false},
 	}
 
 	for _, tc := range cases {
 		schedule := sokofile.FlowSchedule{
 			MinuteValue: tc.input,
 		}
-		result := schedule.Minutes()
+		result, err := schedule.Minutes()
+		if (err == nil) != tc.valid {
+			t.Fatalf("%q got error: %v, want valid: %v", tc.input, err, tc.valid)
+		}
 		if !slices.Equal(result, tc.expected) {
 			t.Fatalf("got: %v, want: %v", result, tc.expected)
 		}
@@ -36,20 +56,25 @@ func TestScheduleHours(t *testing.T) {
 	cases := []struct {
 		input    string
 		expected []int
+		valid    bool
 	}{
-		{"*", nil},
-		{"1", []int{1}},
-		{"4,5,3", []int{4, 5, 3}},
-		{"1, 2 , 3", []int{1, 2, 3}},
-		{"1,23,11", []int{1, 23, 11}},
-		{"1,foo, a bar,,", []int{1}},
+		{"*", nil, true},
+		{"1", []int{1}, true},
+		{"4,5,3", []int{4, 5, 3}, true},
+		{"1, 2 , 3", []int{1, 2, 3}, true},
+		{"1,23,11", []int{1, 23, 11}, true},
+		{"1,foo, a bar,,", nil, false},
+		{"24", nil, false},
 	}
 
 	for _, tc := range cases {
 		schedule := sokofile.FlowSchedule{
 			HourValue: tc.input,
 		}
-		result := schedule.Hours()
+		result, err := schedule.Hours()
+		if (err == nil) != tc.valid {
+			t.Fatalf("%q got error: %v, want valid: %v", tc.input, err, tc.valid)
+		}
 		if !slices.Equal(result, tc.expected) {
 			t.Fatalf("got: %v, want: %v", result, tc.expected)
 		}
@@ -60,21 +85,644 @@ func TestScheduleDays(t *testing.T) {
 	cases := []struct {
 		input    string
 		expected []time.Weekday
+		valid    bool
 	}{
-		{"*", nil},
-		{"Monday", []time.Weekday{time.Monday}},
-		{"Monday  , Tuesday,Saturday", []time.Weekday{time.Monday, time.Tuesday, time.Saturday}},
-		{"monday,friday", []time.Weekday{time.Monday, time.Friday}},
-		{"mon,fri,sat", []time.Weekday{}},
+		{"*", nil, true},
+		{"Monday", []time.Weekday{time.Monday}, true},
+		{"Monday  , Tuesday,Saturday", []time.Weekday{time.Monday, time.Tuesday, time.Saturday}, true},
+		{"monday,friday", []time.Weekday{time.Monday, time.Friday}, true},
+		{"mon,fri,sat", nil, false},
+		{"1-3", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday}, true},
+		{"Friday-Sunday", nil, false},
+		{"5-7", []time.Weekday{time.Friday, time.Saturday, time.Sunday}, true},
+		{"8", nil, false},
 	}
 
 	for _, tc := range cases {
 		schedule := sokofile.FlowSchedule{
 			DayValue: tc.input,
 		}
-		result := schedule.Days()
+		result, err := schedule.Days()
+		if (err == nil) != tc.valid {
+			t.Fatalf("%q got error: %v, want valid: %v", tc.input, err, tc.valid)
+		}
 		if !slices.Equal(result, tc.expected) {
 			t.Fatalf("got: %v, want: %v", result, tc.expected)
 		}
 	}
 }
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+
+	files := []string{
+		"soko.yml",
+		"a/soko.yml",
+		"b/build.soko.yml",
+		"b/c/soko.yml",
+		"b/notes.yml",
+		".hidden/soko.yml",
+	}
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("name: test\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := sokofile.Discover(root)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(root, "a/soko.yml"),
+		filepath.Join(root, "b/build.soko.yml"),
+		filepath.Join(root, "b/c/soko.yml"),
+		filepath.Join(root, "soko.yml"),
+	}
+	if !slices.Equal(result, expected) {
+		t.Fatalf("got: %v, want: %v", result, expected)
+	}
+}
+
+func TestDiscoverSkipsUnreadableDirectories(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+
+	root := t.TempDir()
+	for _, file := range []string{"a/soko.yml", "b/soko.yml", "c/soko.yml"} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("name: test\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	locked := filepath.Join(root, "b")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0o755)
+
+	result, err := sokofile.Discover(root)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expected := []string{filepath.Join(root, "a/soko.yml"), filepath.Join(root, "c/soko.yml")}
+	if !slices.Equal(result, expected) {
+		t.Fatalf("got: %v, want: %v", result, expected)
+	}
+}
+
+func TestScheduleCron(t *testing.T) {
+	cases := []struct {
+		input       string
+		minutes     []int
+		hours       []int
+		daysOfMonth []int
+		months      []time.Month
+		days        []time.Weekday
+	}{
+		{"* * * * *", nil, nil, nil, nil, nil},
+		{"*/15 9-17 * * mon-fri", []int{0, 15, 30, 45}, []int{9, 10, 11, 12, 13, 14, 15, 16, 17}, nil, nil,
+			[]time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+		{"30 2 1,15 jan,Jul *", []int{30}, []int{2}, []int{1, 15}, []time.Month{time.January, time.July}, nil},
+		{"0 0 * * 7", []int{0}, []int{0}, nil, nil, []time.Weekday{time.Sunday}},
+		{"@daily", []int{0}, []int{0}, nil, nil, nil},
+		{"@hourly", []int{0}, nil, nil, nil, nil},
+		{"@monthly", []int{0}, []int{0}, []int{1}, nil, nil},
+		{"@yearly", []int{0}, []int{0}, []int{1}, []time.Month{time.January}, nil},
+		{"@weekly", []int{0}, []int{0}, nil, nil, []time.Weekday{time.Sunday}},
+	}
+
+	for _, tc := range cases {
+		schedule := sokofile.FlowSchedule{Cron: tc.input}
+		if result, _ := schedule.Minutes(); !slices.Equal(result, tc.minutes) {
+			t.Fatalf("%q minutes got: %v, want: %v", tc.input, result, tc.minutes)
+		}
+		if result, _ := schedule.Hours(); !slices.Equal(result, tc.hours) {
+			t.Fatalf("%q hours got: %v, want: %v", tc.input, result, tc.hours)
+		}
+		if result, _ := schedule.DaysOfMonth(); !slices.Equal(result, tc.daysOfMonth) {
+			t.Fatalf("%q days of month got: %v, want: %v", tc.input, result, tc.daysOfMonth)
+		}
+		if result, _ := schedule.Months(); !slices.Equal(result, tc.months) {
+			t.Fatalf("%q months got: %v, want: %v", tc.input, result, tc.months)
+		}
+		if result, _ := schedule.Days(); !slices.Equal(result, tc.days) {
+			t.Fatalf("%q days got: %v, want: %v", tc.input, result, tc.days)
+		}
+		if _, err := schedule.Validate(); err != nil {
+			t.Fatalf("%q got error: %v", tc.input, err)
+		}
+	}
+}
+
+func TestScheduleCronWithFields(t *testing.T) {
+	schedule := sokofile.FlowSchedule{Cron: "0 * * * *", MinuteValue: "75"}
+	if key, err := schedule.Validate(); err == nil || key != "minute" {
+		t.Fatalf("got: %q, %v, expected an error for minute", key, err)
+	}
+}
+
+func TestScheduleCronInvalid(t *testing.T) {
+	cases := []string{
+		"* * *",
+		"* * * * * *",
+		"@fortnightly",
+		"60 * * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * funday",
+	}
+
+	for _, input := range cases {
+		schedule := sokofile.FlowSchedule{Cron: input}
+		if key, err := schedule.Validate(); err == nil || key != "cron" {
+			t.Fatalf("%q got: %q, %v, expected a cron error", input, key, err)
+		}
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+flows:
+  good:
+    schedule:
+      cron: "@daily"
+    steps:
+      - cmd: ["true"]
+  typo:
+    schedule:
+      minute: "75"
+      hour: "*"
+    steps:
+      - cmd: ["true"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("got: %v, expected one validation error", err)
+	}
+
+	e := errs[0]
+	if e.File != path || e.Flow != "typo" || e.Field != "schedule.minute" || e.Line != 10 || e.Column != 15 {
+		t.Fatalf("got: %+v, expected flow typo, field schedule.minute at 10:15", e)
+	}
+}
+
+func TestParseWithoutName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `flows:
+  build:
+    steps:
+      - cmd: ["true"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sokofile.Parse(path); err != nil {
+		t.Fatalf("got: %v, expected a sokofile without a name to parse", err)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	if err := os.WriteFile(path, []byte("# nothing yet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sokofile.Parse(path); err == nil || err.Error() != "sokofile is empty" {
+		t.Fatalf("got: %v, expected: sokofile is empty", err)
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+flows:
+  typo:
+    schedule:
+      minite: "5"
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sokofile.Parse(path); err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Fatalf("got: %v, expected an error on line 5", err)
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+timezone: Europe/London
+flows:
+  inherited:
+    steps:
+      - cmd: ["true"]
+  overridden:
+    timezone: America/New_York
+    steps:
+      - cmd: ["true"]
+  invalid:
+    timezone: Mars/Olympus_Mons
+    steps:
+      - cmd: ["true"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Flow != "invalid" || errs[0].Field != "timezone" {
+		t.Fatalf("got: %v, expected one timezone error for flow invalid", err)
+	}
+
+	location, err := project.Location(project.Flows["inherited"])
+	if err != nil || location.String() != "Europe/London" {
+		t.Fatalf("got: %v, %v, expected: Europe/London", location, err)
+	}
+
+	location, err = project.Location(project.Flows["overridden"])
+	if err != nil || location.String() != "America/New_York" {
+		t.Fatalf("got: %v, %v, expected: America/New_York", location, err)
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+flows:
+  build:
+    timeout: 1h
+    steps:
+      - cmd: ["make"]
+        timeout: 90s
+      - cmd: ["true"]
+        timeout: -5s
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "steps[1].timeout" || errs[0].Line != 9 {
+		t.Fatalf("got: %v, expected one timeout error on line 9", err)
+	}
+
+	flow := project.Flows["build"]
+	if flow.Timeout != time.Hour || flow.Steps[0].Timeout != 90*time.Second {
+		t.Fatalf("got: %v, %v, expected: 1h, 90s", flow.Timeout, flow.Steps[0].Timeout)
+	}
+}
+
+func TestParseRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+flows:
+  deploy:
+    retry:
+      max_attempts: 2
+    steps:
+      - cmd: ["curl", "example.com"]
+        retry:
+          max_attempts: 5
+          backoff: exponential
+          delay: 1s
+          max_delay: 1m
+          exit_codes: [6, 7]
+      - cmd: ["true"]
+        retry:
+          max_attempts: 3
+          backoff: linear
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "steps[1].retry.backoff" || errs[0].Line != 17 {
+		t.Fatalf("got: %v, expected one backoff error on line 17", err)
+	}
+
+	retry := project.Flows["deploy"].Steps[0].Retry
+	if retry.MaxAttempts != 5 || retry.Backoff != "exponential" || retry.Delay != time.Second || retry.MaxDelay != time.Minute || !slices.Equal(retry.ExitCodes, []int{6, 7}) {
+		t.Fatalf("got: %+v, expected the step's retry policy", retry)
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+env:
+  LEVEL: project
+  PROJECT: "1"
+secrets:
+  TOKEN: project_token
+flows:
+  deploy:
+    env:
+      LEVEL: flow
+    secrets:
+      TOKEN: deploy_token
+    steps:
+      - cmd: ["deploy"]
+        env:
+          LEVEL: step
+      - cmd: ["notify"]
+        env:
+          "BAD=NAME": "x"
+        outputs: [id, id, "not valid"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 3 || errs[0].Field != "steps[1].outputs" || errs[2].Field != "steps[1].env" {
+		t.Fatalf("got: %v, expected two output errors and an env error", err)
+	}
+
+	flow := project.Flows["deploy"]
+	env := project.StepEnv(flow, flow.Steps[0])
+	if !maps.Equal(env, map[string]string{"LEVEL": "step", "PROJECT": "1"}) {
+		t.Fatalf("got: %v, expected step env to override the flow and project", env)
+	}
+
+	secrets := project.StepSecrets(flow, flow.Steps[0])
+	if !maps.Equal(secrets, map[string]string{"TOKEN": "deploy_token"}) {
+		t.Fatalf("got: %v, expected flow secrets to override the project", secrets)
+	}
+}
+
+func TestParseShellAndWorkdir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "soko.yml")
+	contents := `name: test
+workdir: src
+flows:
+  build:
+    shell: ["bash", "-c"]
+    steps:
+      - run: |
+          make
+          make install
+      - cmd: ["ls"]
+        workdir: /tmp
+      - run: echo hi
+        shell: ["sh", "-ec"]
+        workdir: out
+  broken:
+    steps:
+      - cmd: ["true"]
+        run: "true"
+      - workdir: src
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("got: %v, expected two step errors", err)
+	}
+	if errs[0].Field != "steps[0].run" || errs[1].Field != "steps[1]" {
+		t.Fatalf("got: %v, expected errors for steps[0].run and steps[1]", errs)
+	}
+
+	flow := project.Flows["build"]
+	cases := []struct {
+		args    []string
+		workdir string
+	}{
+		{[]string{"bash", "-c", "make\nmake install\n"}, filepath.Join(dir, "src")},
+		{[]string{"ls"}, "/tmp"},
+		{[]string{"sh", "-ec", "echo hi"}, filepath.Join(dir, "out")},
+	}
+	for i, tc := range cases {
+		step := flow.Steps[i]
+		if args := project.StepArgs(flow, step); !slices.Equal(args, tc.args) {
+			t.Fatalf("step %d got args: %q, expected: %q", i, args, tc.args)
+		}
+		if workdir := project.StepWorkdir(flow, step); workdir != tc.workdir {
+			t.Fatalf("step %d got workdir: %s, expected: %s", i, workdir, tc.workdir)
+		}
+	}
+}
+
+func TestParseInputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `name: test
+flows:
+  deploy:
+    inputs:
+      target:
+        type: choice
+        options: [staging, production]
+        default: staging
+      dry_run:
+        type: bool
+        default: true
+      version: {}
+    steps:
+      - run: deploy ${{ inputs.version }}
+  broken:
+    schedule:
+      cron: "@daily"
+    inputs:
+      colour:
+        type: colour
+      count:
+        type: bool
+        default: lots
+      size:
+        type: choice
+      tag:
+        default: latest
+    steps:
+      - cmd: ["true"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got: %v, expected validation errors", err)
+	}
+
+	fields := make([]string, len(errs))
+	for i, err := range errs {
+		fields[i] = err.Field
+	}
+	expected := []string{"inputs.colour.type", "inputs.colour", "inputs.count.default", "inputs.size.options", "inputs.size"}
+	if !slices.Equal(fields, expected) {
+		t.Fatalf("got: %v, expected: %v", fields, expected)
+	}
+
+	inputs := project.Flows["deploy"].Inputs
+	if *inputs["dry_run"].Default != "true" || inputs["version"].Default != nil {
+		t.Fatalf("got: %+v, expected defaults to be read as written", inputs)
+	}
+}
+
+func TestParseNeeds(t *testing.T) {
+	cases := []struct {
+		steps  string
+		fields []string
+	}{
+		{`
+      - id: build
+        cmd: ["make"]
+      - id: lint
+        cmd: ["lint"]
+      - id: test
+        needs: [build]
+        cmd: ["test"]
+      - needs: [lint, test]
+        cmd: ["package"]
+`, nil},
+		{`
+      - id: build
+        cmd: ["make"]
+      - needs: [missing, build]
+        cmd: ["test"]
+`, []string{"steps[1].needs"}},
+		{`
+      - id: a
+        needs: [b]
+        cmd: ["true"]
+      - id: b
+        cmd: ["true"]
+`, []string{"steps[0].needs"}},
+		{`
+      - id: a
+        cmd: ["true"]
+      - id: a
+        cmd: ["true"]
+      - id: "not valid"
+        cmd: ["true"]
+`, []string{"steps[1].id", "steps[2].id"}},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "soko.yml")
+		contents := "name: test\nflows:\n  build:\n    steps:" + tc.steps
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := sokofile.Parse(path)
+		var errs sokofile.ValidationErrors
+		if err != nil && !errors.As(err, &errs) {
+			t.Fatal(err)
+		}
+
+		var fields []string
+		for _, err := range errs {
+			fields = append(fields, err.Field)
+		}
+		if !slices.Equal(fields, tc.fields) {
+			t.Fatalf("%s got: %v, expected: %v", tc.steps, err, tc.fields)
+		}
+	}
+}
+
+func TestParseTriggers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soko.yml")
+	contents := `
+name: test
+flows:
+  build:
+    steps:
+      - cmd: ["true"]
+  deploy:
+    inputs:
+      target: {}
+    on:
+      - flow: build
+      - flow: other.release
+        when: completed
+      - flow: missing
+      - flow: build
+        when: sometimes
+    steps:
+      - cmd: ["true"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := sokofile.Parse(path)
+	var errs sokofile.ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got: %v, expected validation errors", err)
+	}
+
+	fields := make([]string, len(errs))
+	for i, err := range errs {
+		fields[i] = err.Field
+	}
+	expected := []string{"inputs.target", "on[3].when", "on[2].flow"}
+	if !slices.Equal(fields, expected) {
+		t.Fatalf("got: %v, expected: %v", err, expected)
+	}
+}
+
+func TestParseCatchup(t *testing.T) {
+	cases := []struct {
+		flow   string
+		fields []string
+	}{
+		{"catchup: all\n    catchup_limit: 5\n    schedule:\n      cron: '@hourly'", nil},
+		{"catchup: once\n    schedule:\n      cron: '@hourly'", nil},
+		{"catchup: sometimes\n    schedule:\n      cron: '@hourly'", []string{"catchup"}},
+		{"catchup: once", []string{"catchup"}},
+		{"catchup: once\n    catchup_limit: 5\n    schedule:\n      cron: '@hourly'", []string{"catchup_limit"}},
+		{"catchup: all\n    catchup_limit: -1\n    schedule:\n      cron: '@hourly'", []string{"catchup_limit"}},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "soko.yml")
+		contents := "name: test\nflows:\n  build:\n    steps:\n      - cmd: [\"true\"]\n    " + tc.flow + "\n"
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := sokofile.Parse(path)
+		var errs sokofile.ValidationErrors
+		if err != nil && !errors.As(err, &errs) {
+			t.Fatal(err)
+		}
+
+		var fields []string
+		for _, err := range errs {
+			fields = append(fields, err.Field)
+		}
+		if !slices.Equal(fields, tc.fields) {
+			t.Fatalf("%q got: %v, expected: %v", tc.flow, err, tc.fields)
+		}
+	}
+}
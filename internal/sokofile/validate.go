@@ -0,0 +1,371 @@
+package sokofile
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError describes a problem with a single field of a sokofile.
+type ValidationError struct {
+	// File is empty for flows parsed on their own.
+	File   string
+	Flow   string
+	Field  string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	location := fmt.Sprintf("%d:%d", e.Line, e.Column)
+	if e.File != "" {
+		location = e.File + ":" + location
+	}
+	if e.Flow == "" {
+		return fmt.Sprintf("%s: %s: %v", location, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: flow %s: %s: %v", location, e.Flow, e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors is every problem found in a sokofile.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// lookup follows a path of mapping keys and sequence indices from node,
+// returning the deepest node found along it.
+func lookup(node *yaml.Node, path ...string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, key := range path {
+		if node.Kind == yaml.SequenceNode {
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return node
+			}
+			node = node.Content[index]
+			continue
+		}
+
+		if node.Kind != yaml.MappingNode {
+			return node
+		}
+
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return node
+		}
+	}
+
+	return node
+}
+
+func validateEnvName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+	return nil
+}
+
+// reporter records a problem with a field at a path of yaml keys.
+type reporter func(field string, err error, path ...string)
+
+func checkEnv(report reporter, field string, env map[string]string, path ...string) {
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		if err := validateEnvName(key); err != nil {
+			report(field, err, path...)
+		}
+	}
+}
+
+func checkShell(report reporter, field string, shell []string, path ...string) {
+	if shell != nil && len(shell) == 0 {
+		report(field, errors.New("must not be empty"), path...)
+	}
+}
+
+func validate(file string, root *yaml.Node, project *Project) error {
+	errs := make(ValidationErrors, 0)
+	reportIn := func(flow string, prefix ...string) reporter {
+		return func(field string, err error, path ...string) {
+			node := lookup(root, append(slices.Clone(prefix), path...)...)
+			errs = append(errs, &ValidationError{
+				File:   file,
+				Flow:   flow,
+				Field:  field,
+				Line:   node.Line,
+				Column: node.Column,
+				Err:    err,
+			})
+		}
+	}
+	report := reportIn("")
+
+	checkShell(report, "shell", project.Shell, "shell")
+	checkEnv(report, "env", project.Env, "env")
+	checkEnv(report, "secrets", project.Secrets, "secrets")
+
+	if project.Timezone != "" {
+		if _, err := time.LoadLocation(project.Timezone); err != nil {
+			report("timezone", err, "timezone")
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(project.Flows)) {
+		report := reportIn(name, "flows", name)
+		validateFlow(report, project.Flows[name])
+
+		// flows of other projects always have a dot in their ids
+		for i, trigger := range project.Flows[name].On {
+			_, ok := project.Flows[trigger.Flow]
+			if trigger.Flow != "" && !ok && !strings.Contains(trigger.Flow, ".") {
+				report(fmt.Sprintf("on[%d].flow", i), fmt.Errorf("no flow %q in this project", trigger.Flow), "on", strconv.Itoa(i), "flow")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// identifier matches the names of inputs and outputs.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Validate checks an input, returning the yaml key of the first invalid field
+// along with the problem.
+func (i Input) Validate() (string, error) {
+	switch i.Type {
+	case "", "string", "bool":
+		if len(i.Options) > 0 {
+			return "options", errors.New("only applies to choice inputs")
+		}
+	case "choice":
+		if len(i.Options) == 0 {
+			return "options", errors.New("choice inputs need at least one option")
+		}
+	default:
+		return "type", fmt.Errorf("unknown type %q, expected string, bool or choice", i.Type)
+	}
+
+	if i.Default == nil {
+		return "", nil
+	}
+	if _, err := strconv.ParseBool(*i.Default); i.Type == "bool" && err != nil {
+		return "default", fmt.Errorf("%q is not true or false", *i.Default)
+	}
+	if i.Type == "choice" && !slices.Contains(i.Options, *i.Default) {
+		return "default", fmt.Errorf("%q is not one of the options", *i.Default)
+	}
+	return "", nil
+}
+
+// checkNeeds checks that steps only need steps that exist, and don't need
+// each other in a cycle.
+func checkNeeds(report reporter, steps []FlowStep) {
+	ids := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if step.Id == "" {
+			continue
+		}
+		if !identifier.MatchString(step.Id) {
+			report(fmt.Sprintf("steps[%d].id", i), fmt.Errorf("invalid step id %q", step.Id), "steps", strconv.Itoa(i), "id")
+		} else if _, ok := ids[step.Id]; ok {
+			report(fmt.Sprintf("steps[%d].id", i), fmt.Errorf("step id %s is used twice", step.Id), "steps", strconv.Itoa(i), "id")
+		} else {
+			ids[step.Id] = i
+		}
+	}
+
+	needs := make([][]int, len(steps))
+	valid := true
+	for i, step := range steps {
+		if step.Needs == nil && i > 0 {
+			needs[i] = []int{i - 1}
+		}
+		for j, id := range step.Needs {
+			need, ok := ids[id]
+			if !ok || need == i {
+				report(fmt.Sprintf("steps[%d].needs", i), fmt.Errorf("no other step has id %q", id), "steps", strconv.Itoa(i), "needs", strconv.Itoa(j))
+				valid = false
+				continue
+			}
+			needs[i] = append(needs[i], need)
+		}
+	}
+	if !valid {
+		return
+	}
+
+	// remove steps that need nothing left, until only cycles remain
+	remaining := make([]int, len(steps))
+	for i := range steps {
+		remaining[i] = len(needs[i])
+	}
+	queue := make([]int, 0, len(steps))
+	for i, count := range remaining {
+		if count == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		done := queue[0]
+		queue = queue[1:]
+		for i := range steps {
+			for _, need := range needs[i] {
+				if need == done {
+					remaining[i]--
+					if remaining[i] == 0 {
+						queue = append(queue, i)
+					}
+				}
+			}
+		}
+	}
+
+	for i, count := range remaining {
+		if count > 0 {
+			report(fmt.Sprintf("steps[%d].needs", i), errors.New("steps need each other in a cycle"), "steps", strconv.Itoa(i), "needs")
+			return
+		}
+	}
+}
+
+// validateFlow checks a single flow, reporting paths relative to the flow.
+func validateFlow(report reporter, flow Flow) {
+	if flow.Timezone != "" {
+		if _, err := time.LoadLocation(flow.Timezone); err != nil {
+			report("timezone", err, "timezone")
+		}
+	}
+
+	switch flow.Catchup {
+	case "", "skip", "once", "all":
+	default:
+		report("catchup", fmt.Errorf("unknown policy %q, expected skip, once or all", flow.Catchup), "catchup")
+	}
+	if flow.Catchup != "" && flow.Schedule == nil {
+		report("catchup", errors.New("only applies to scheduled flows"), "catchup")
+	}
+
+	if flow.CatchupLimit < 0 {
+		report("catchup_limit", errors.New("must not be negative"), "catchup_limit")
+	} else if flow.CatchupLimit > 0 && flow.Catchup != "all" {
+		report("catchup_limit", errors.New("only applies to catchup: all"), "catchup_limit")
+	}
+
+	if flow.MaxConcurrent < 0 {
+		report("max_concurrent", errors.New("must not be negative"), "max_concurrent")
+	}
+
+	if flow.Timeout < 0 {
+		report("timeout", errors.New("must not be negative"), "timeout")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(flow.Inputs)) {
+		input := flow.Inputs[name]
+		if !identifier.MatchString(name) {
+			report("inputs", fmt.Errorf("invalid input name %q", name), "inputs")
+		}
+		if key, err := input.Validate(); err != nil {
+			report("inputs."+name+"."+key, err, "inputs", name, key)
+		}
+		if flow.Schedule != nil && input.Default == nil {
+			report("inputs."+name, errors.New("scheduled flows need a default for every input"), "inputs", name)
+		} else if len(flow.On) > 0 && input.Default == nil {
+			report("inputs."+name, errors.New("triggered flows need a default for every input"), "inputs", name)
+		}
+	}
+
+	checkShell(report, "shell", flow.Shell, "shell")
+	checkEnv(report, "env", flow.Env, "env")
+	checkEnv(report, "secrets", flow.Secrets, "secrets")
+
+	if flow.Retry != nil {
+		if key, err := flow.Retry.Validate(); err != nil {
+			report("retry."+key, err, "retry", key)
+		}
+	}
+
+	for i, trigger := range flow.On {
+		index := strconv.Itoa(i)
+		if trigger.Flow == "" {
+			report(fmt.Sprintf("on[%d].flow", i), errors.New("flow is required"), "on", index)
+		}
+		switch trigger.When {
+		case "", "succeeded", "failed", "completed":
+		default:
+			report(fmt.Sprintf("on[%d].when", i), fmt.Errorf("unknown value %q, expected succeeded, failed or completed", trigger.When), "on", index, "when")
+		}
+	}
+
+	checkNeeds(report, flow.Steps)
+
+	for i, step := range flow.Steps {
+		index := strconv.Itoa(i)
+		switch {
+		case len(step.Cmd) == 0 && step.Run == "":
+			report(fmt.Sprintf("steps[%d]", i), errors.New("either cmd or run is required"), "steps", index)
+		case len(step.Cmd) > 0 && step.Run != "":
+			report(fmt.Sprintf("steps[%d].run", i), errors.New("cannot be combined with cmd"), "steps", index, "run")
+		case len(step.Cmd) > 0 && step.Shell != nil:
+			report(fmt.Sprintf("steps[%d].shell", i), errors.New("only applies to run"), "steps", index, "shell")
+		}
+		checkShell(report, fmt.Sprintf("steps[%d].shell", i), step.Shell, "steps", index, "shell")
+
+		if step.Timeout < 0 {
+			report(fmt.Sprintf("steps[%d].timeout", i), errors.New("must not be negative"), "steps", index, "timeout")
+		}
+
+		for j, name := range step.Outputs {
+			if !identifier.MatchString(name) {
+				report(fmt.Sprintf("steps[%d].outputs", i), fmt.Errorf("invalid output name %q", name), "steps", index, "outputs", strconv.Itoa(j))
+			} else if slices.Index(step.Outputs, name) < j {
+				report(fmt.Sprintf("steps[%d].outputs", i), fmt.Errorf("output %s is declared twice", name), "steps", index, "outputs", strconv.Itoa(j))
+			}
+		}
+
+		checkEnv(report, fmt.Sprintf("steps[%d].env", i), step.Env, "steps", index, "env")
+		checkEnv(report, fmt.Sprintf("steps[%d].secrets", i), step.Secrets, "steps", index, "secrets")
+
+		if step.Retry != nil {
+			if key, err := step.Retry.Validate(); err != nil {
+				report(fmt.Sprintf("steps[%d].retry.%s", i, key), err, "steps", index, "retry", key)
+			}
+		}
+	}
+
+	if flow.Schedule != nil {
+		if key, err := flow.Schedule.Validate(); err != nil {
+			report("schedule."+key, err, "schedule", key)
+		}
+	}
+}
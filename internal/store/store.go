@@ -0,0 +1,247 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/fourls/soko/internal/engine"
+)
+
+// maxRecordSize is the longest record read back. Longer ones are skipped, so
+// a single huge job can't stop the history from loading.
+const maxRecordSize = 64 << 20
+
+// maxSavedOutput caps how much output is saved for each job, counting
+// lineOverhead for every line on top of its text. Along with the worst case
+// for escaping text as JSON, this keeps records well under maxRecordSize.
+const (
+	maxSavedOutput = 8 << 20
+	lineOverhead   = 128
+)
+
+// compactSlack is how many records beyond one per job may be appended before
+// the file is compacted again.
+const compactSlack = 1000
+
+type record struct {
+	Id   engine.JobId   `json:"id"`
+	Info engine.JobInfo `json:"info"`
+}
+
+// FileStore keeps job records in a JSON-lines file. Every save appends a
+// record, and the file is compacted down to the latest record for each job
+// when it is opened, and again whenever superseded records pile up.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+	// jobs is the set of jobs with records, and records how many records
+	// the file holds.
+	jobs    map[engine.JobId]bool
+	records int
+}
+
+var _ engine.JobStore = (*FileStore)(nil)
+
+// Open reads the job history at path, creating the file if it does not exist.
+func Open(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compact rewrites the file with only the latest record for each job, and
+// reopens it for appending.
+func (s *FileStore) compact() error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+
+	jobs, err := read(s.path)
+	if err != nil {
+		return err
+	}
+
+	if err := compact(s.path, jobs); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.file = file
+	s.encoder = json.NewEncoder(file)
+	s.jobs = make(map[engine.JobId]bool, len(jobs))
+	for id := range jobs {
+		s.jobs[id] = true
+	}
+	s.records = len(jobs)
+	return nil
+}
+
+func read(path string) (map[engine.JobId]engine.JobInfo, error) {
+	jobs := make(map[engine.JobId]engine.JobInfo)
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return jobs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := readRecord(reader)
+		if errors.Is(err, errRecordTooLong) {
+			log.Printf("Skipping job record at %s:%d: longer than %d bytes", path, line, maxRecordSize)
+			continue
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				// a crash mid-write can leave a partial record behind
+				log.Printf("Skipping unreadable job record at %s:%d: %v", path, line, err)
+			} else {
+				jobs[r.Id] = r.Info
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return jobs, nil
+		}
+	}
+}
+
+var errRecordTooLong = errors.New("record too long")
+
+// readRecord reads a line, discarding the rest of it and returning
+// errRecordTooLong if it is longer than maxRecordSize.
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	var data []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			data = append(data, chunk...)
+			if len(data) > maxRecordSize {
+				tooLong = true
+				data = nil
+			}
+		}
+
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case tooLong && (err == nil || errors.Is(err, io.EOF)):
+			return nil, errRecordTooLong
+		default:
+			return data, err
+		}
+	}
+}
+
+func compact(path string, jobs map[engine.JobId]engine.JobInfo) error {
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for id, info := range jobs {
+		if err := encoder.Encode(record{id, info}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+func (s *FileStore) Load() (map[engine.JobId]engine.JobInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return read(s.path)
+}
+
+func (s *FileStore) Save(id engine.JobId, info engine.JobInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.encoder.Encode(record{id, truncateOutput(info)}); err != nil {
+		return err
+	}
+
+	s.jobs[id] = true
+	s.records++
+	if s.records > 2*len(s.jobs)+compactSlack {
+		return s.compact()
+	}
+	return nil
+}
+
+// truncateOutput returns info with only as much output as maxSavedOutput
+// allows, dropping the lines after that and noting that it did.
+func truncateOutput(info engine.JobInfo) engine.JobInfo {
+	size := 0
+	for i, step := range info.Steps {
+		for j, line := range step.Lines {
+			size += len(line.Text) + lineOverhead
+			if size <= maxSavedOutput {
+				continue
+			}
+
+			steps := slices.Clone(info.Steps[:i+1])
+			steps[i].Lines = append(slices.Clone(step.Lines[:j]), engine.OutputLine{
+				Time:    line.Time,
+				Stream:  engine.System,
+				Text:    "Output truncated, as it is too long to keep",
+				Attempt: line.Attempt,
+			})
+			for _, rest := range info.Steps[i+1:] {
+				rest.Lines = nil
+				steps = append(steps, rest)
+			}
+			info.Steps = steps
+			return info
+		}
+	}
+	return info
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,176 @@
+package store_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fourls/soko/internal/engine"
+	"github.com/fourls/soko/internal/store"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saves := []struct {
+		id   engine.JobId
+		info engine.JobInfo
+	}{
+		{"a", engine.JobInfo{FlowId: "p.one", State: engine.JobPending}},
+		{"b", engine.JobInfo{FlowId: "p.two", State: engine.JobRunning}},
+		{"a", engine.JobInfo{FlowId: "p.one", State: engine.JobSucceeded, Steps: []engine.StepInfo{{Input: "true", Lines: []engine.OutputLine{{Stream: engine.Stderr, Text: "ok"}}}}}},
+	}
+	for _, save := range saves {
+		if err := s.Save(save.id, save.info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	jobs, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 2 {
+		t.Fatalf("got: %d jobs, expected: 2", len(jobs))
+	}
+	if a := jobs["a"]; a.State != engine.JobSucceeded || len(a.Steps) != 1 || a.Steps[0].Lines[0] != (engine.OutputLine{Stream: engine.Stderr, Text: "ok"}) {
+		t.Fatalf("got: %+v for job a, expected its latest record", a)
+	}
+	if b := jobs["b"]; b.State != engine.JobRunning || b.FlowId != "p.two" {
+		t.Fatalf("got: %+v for job b", b)
+	}
+}
+
+func TestFileStoreSkipsPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	contents := `{"id":"a","info":{"FlowId":"p.one","State":"succeeded"}}
+{"id":"b","info":{"FlowId":"p.tw`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	jobs, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs["a"].State != engine.JobSucceeded {
+		t.Fatalf("got: %+v, expected only job a", jobs)
+	}
+}
+
+func TestFileStoreSkipsOversizedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	huge := `{"id":"b","info":{"FlowId":"p.two","State":"succeeded","Steps":[{"Lines":[{"Text":"` + strings.Repeat("x", 65<<20) + `"}]}]}}`
+	contents := `{"id":"a","info":{"FlowId":"p.one","State":"succeeded"}}` + "\n" + huge + "\n" +
+		`{"id":"c","info":{"FlowId":"p.three","State":"failed"}}` + "\n"
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	jobs, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs["a"].State != engine.JobSucceeded || jobs["c"].State != engine.JobFailed {
+		t.Fatalf("got: %d jobs, expected jobs a and c", len(jobs))
+	}
+}
+
+func TestFileStoreTruncatesOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	line := engine.OutputLine{Stream: engine.Stdout, Text: strings.Repeat("x", 1<<20)}
+	info := engine.JobInfo{
+		FlowId: "p.loud",
+		Steps: []engine.StepInfo{
+			{Lines: slices.Repeat([]engine.OutputLine{line}, 6)},
+			{Lines: slices.Repeat([]engine.OutputLine{line}, 6)},
+		},
+	}
+	if err := s.Save("a", info); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := jobs["a"].Steps
+	if len(steps) != 2 || len(steps[0].Lines) != 6 || len(steps[1].Lines) != 2 {
+		t.Fatalf("got: %d steps, expected the output to be cut off in the second", len(steps))
+	}
+	if last := steps[1].Lines[1]; last.Stream != engine.System {
+		t.Fatalf("got: %+v, expected a note that the output was truncated", last)
+	}
+	if len(info.Steps[1].Lines) != 6 {
+		t.Fatalf("got: the saved job was modified")
+	}
+}
+
+func TestFileStoreCompactsWhileOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := range 5000 {
+		if err := s.Save("a", engine.JobInfo{FlowId: "p.one", CurrentStep: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := bytes.Count(data, []byte("\n")); records > 2000 {
+		t.Fatalf("got: %d records for one job, expected the file to be compacted", records)
+	}
+
+	jobs, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs["a"].CurrentStep != 4999 {
+		t.Fatalf("got: %+v, expected the latest record", jobs["a"])
+	}
+}
@@ -15,27 +15,80 @@ func parse(file string) *template.Template {
 
 var (
 	dashboardTemplate = parse("dashboard.html")
+	jobTemplate       = parse("job.html")
 )
 
 type Flow struct {
 	Id       string
 	Name     string
 	Schedule string
+	// Triggers describe the ends of other flows' jobs that start the flow.
+	Triggers []string
+	Inputs   []Input
 	Jobs     []*Job
 }
 
+type Input struct {
+	Name        string
+	Type        string
+	Description string
+	Default     string
+	Required    bool
+	Options     []string
+}
+
 type Job struct {
-	Id     string
-	Flow   *Flow
-	State  string
-	FlowId string
+	Id          string
+	Flow        *Flow
+	State       string
+	FlowId      string
+	TriggeredBy string
+	Cancellable bool
+	// Started and Duration are empty until the job starts and finishes.
+	Started  string
+	Duration string
 }
 
 type DashboardParams struct {
 	Flows map[string]Flow
-	Jobs  map[string]Job
+	// Jobs are the latest jobs, newest first.
+	Jobs []Job
 }
 
 func Dashboard(w io.Writer, p DashboardParams) error {
 	return dashboardTemplate.Execute(w, p)
 }
+
+type Step struct {
+	Id       string
+	State    string
+	Input    string
+	Duration string
+	// Exit describes how the latest attempt at the step exited, if any.
+	Exit     string
+	Outputs  map[string]string
+	Lines    []Line
+	Attempts []Attempt
+}
+
+type Attempt struct {
+	State    string
+	Exit     string
+	Duration string
+}
+
+type Line struct {
+	Time   string
+	Stream string
+	Text   string
+}
+
+type JobParams struct {
+	Job
+	Inputs map[string]string
+	Steps  []Step
+}
+
+func JobPage(w io.Writer, p JobParams) error {
+	return jobTemplate.Execute(w, p)
+}
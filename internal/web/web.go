@@ -1,33 +1,119 @@
 package web
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/fourls/soko/internal/engine"
 	"github.com/fourls/soko/internal/web/html"
 	"github.com/gorilla/mux"
 )
 
+// dashboardJobs is how many of the latest jobs the dashboard shows.
+const dashboardJobs = 50
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateTime)
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func formatExit(attempt engine.StepAttempt) string {
+	if attempt.Signal != "" {
+		return "killed by signal: " + attempt.Signal
+	}
+	return fmt.Sprintf("exit code %d", attempt.ExitCode)
+}
+
+func templateJob(id engine.JobId, job engine.JobInfo) html.Job {
+	return html.Job{
+		Id:          string(id),
+		State:       job.State.String(),
+		FlowId:      string(job.FlowId),
+		TriggeredBy: string(job.TriggeredBy),
+		Cancellable: !job.State.Finished(),
+		Started:     formatTime(job.StartedAt),
+		Duration:    formatDuration(job.Duration()),
+	}
+}
+
+func templateTriggers(triggers []engine.Trigger) []string {
+	res := make([]string, len(triggers))
+	for i, trigger := range triggers {
+		res[i] = fmt.Sprintf("%s %s", trigger.Flow, trigger.When)
+	}
+	return res
+}
+
+func templateInputs(inputs []engine.Input) []html.Input {
+	res := make([]html.Input, len(inputs))
+	for i, input := range inputs {
+		res[i] = html.Input{
+			Name:        input.Name,
+			Type:        input.Type.String(),
+			Description: input.Description,
+			Required:    input.Default == nil,
+			Options:     input.Options,
+		}
+		if input.Default != nil {
+			res[i].Default = *input.Default
+		}
+	}
+	return res
+}
+
+// formInputs reads the values of a flow's inputs from a submitted run form.
+// Unticked checkboxes aren't submitted, so bools default to false, and empty
+// fields are left out so required inputs are reported as missing.
+func formInputs(r *http.Request, inputs []engine.Input) map[string]string {
+	res := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		value := r.PostFormValue(input.Name)
+		switch {
+		case input.Type == engine.InputBool:
+			res[input.Name] = strconv.FormatBool(value != "")
+		case value != "" || input.Default != nil:
+			res[input.Name] = value
+		}
+	}
+	return res
+}
+
 func ConfigureRouter(router *mux.Router, jobEngine *engine.JobEngine) {
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		engineFlows := jobEngine.Flows.Snapshot()
 		templateFlows := make(map[string]html.Flow, len(engineFlows))
 		for id, flow := range engineFlows {
-			templateFlows[string(id)] = html.Flow{
+			templateFlow := html.Flow{
 				Id:       string(id),
-				Schedule: flow.Schedule.String(),
+				Triggers: templateTriggers(flow.Triggers),
+				Inputs:   templateInputs(flow.Inputs),
+			}
+			if flow.Schedule != nil {
+				templateFlow.Schedule = flow.Schedule.String()
 			}
+			templateFlows[string(id)] = templateFlow
 		}
 
-		engineJobs := jobEngine.Jobs.Snapshot()
-		templateJobs := make(map[string]html.Job, len(engineJobs))
-		for id, job := range engineJobs {
-			templateJobs[string(id)] = html.Job{
-				Id:     string(id),
-				State:  job.State.String(),
-				FlowId: string(job.FlowId),
-			}
+		entries, _, err := jobEngine.QueryJobs(engine.JobQuery{Limit: dashboardJobs})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		templateJobs := make([]html.Job, len(entries))
+		for i, entry := range entries {
+			templateJobs[i] = templateJob(entry.Id, entry.Info)
 		}
 
 		html.Dashboard(w, html.DashboardParams{
@@ -35,5 +121,83 @@ func ConfigureRouter(router *mux.Router, jobEngine *engine.JobEngine) {
 			Jobs:  templateJobs,
 		})
 	})
+
+	router.HandleFunc("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := engine.JobId(vars["id"])
+
+		job, ok := jobEngine.GetJob(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		steps := make([]html.Step, len(job.Steps))
+		for i, step := range job.Steps {
+			lines := make([]html.Line, len(step.Lines))
+			for j, line := range step.Lines {
+				lines[j] = html.Line{
+					Time:   line.Time.Format(time.TimeOnly + ".000"),
+					Stream: line.Stream.String(),
+					Text:   line.Text,
+				}
+			}
+
+			attempts := make([]html.Attempt, len(step.Attempts))
+			for j, attempt := range step.Attempts {
+				attempts[j] = html.Attempt{
+					State:    attempt.State.String(),
+					Exit:     formatExit(attempt),
+					Duration: formatDuration(attempt.Duration()),
+				}
+			}
+
+			steps[i] = html.Step{
+				Id:       step.Id,
+				State:    step.State.String(),
+				Input:    step.Input,
+				Duration: formatDuration(step.Duration()),
+				Outputs:  step.Outputs,
+				Lines:    lines,
+				Attempts: attempts,
+			}
+			if result, ok := step.Result(); ok {
+				steps[i].Exit = formatExit(result)
+			}
+		}
+
+		html.JobPage(w, html.JobParams{
+			Job:    templateJob(id, job),
+			Inputs: job.Inputs,
+			Steps:  steps,
+		})
+	}).Methods("GET")
+
+	router.HandleFunc("/flows/{id}/run", func(w http.ResponseWriter, r *http.Request) {
+		flowId := engine.FlowId(mux.Vars(r)["id"])
+
+		flow, ok := jobEngine.Flows.Read(flowId)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		jobId, err := jobEngine.StartJobWithOptions(flowId, engine.JobOptions{
+			Inputs: formInputs(r, flow.Inputs),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/jobs/"+string(jobId), http.StatusSeeOther)
+	}).Methods("POST")
+
+	router.HandleFunc("/jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		jobEngine.CancelJob(engine.JobId(vars["id"]))
+		http.Redirect(w, r, "/jobs/"+vars["id"], http.StatusSeeOther)
+	}).Methods("POST")
+
 	log.Println("Configured web routes")
 }